@@ -0,0 +1,74 @@
+package handler
+
+import "net/http"
+
+// BufferingWriter is an http.ResponseWriter that buffers the status code and body written to it in memory,
+// instead of sending them to the underlying http.ResponseWriter immediately. This allows middleware to inspect
+// or rewrite the response before it is sent. Headers are not buffered, they are set in the underlying
+// http.ResponseWriter directly.
+//
+// BufferingWriter uses the same implementation as the handlers returned by this package when using the
+// AfterResponse response mode.
+type BufferingWriter struct {
+	rw *responseWriter
+}
+
+var _ http.Flusher = (*BufferingWriter)(nil)
+
+// NewBufferingResponseWriter returns a new BufferingWriter that buffers the response to r, which will eventually
+// be sent to w when calling Flush.
+func NewBufferingResponseWriter(w http.ResponseWriter, r *http.Request) *BufferingWriter {
+	return &BufferingWriter{
+		rw: &responseWriter{
+			w:                w,
+			r:                r,
+			bufferBody:       true,
+			streamAfterFlush: true,
+		},
+	}
+}
+
+// Header implements http.ResponseWriter.
+func (w *BufferingWriter) Header() http.Header {
+	return w.rw.Header()
+}
+
+// Write implements http.ResponseWriter. Before Flush has been called, b is appended to the buffered body.
+// After Flush has been called, b is written to the underlying http.ResponseWriter directly.
+func (w *BufferingWriter) Write(b []byte) (int, error) {
+	return w.rw.Write(b)
+}
+
+// WriteHeader implements http.ResponseWriter. Before Flush has been called, the status code is buffered.
+// After Flush has been called, calling WriteHeader has no effect.
+func (w *BufferingWriter) WriteHeader(statusCode int) {
+	w.rw.WriteHeader(statusCode)
+}
+
+// Body returns the body buffered so far. It does not include any data written after Flush has been called.
+func (w *BufferingWriter) Body() []byte {
+	return Body(w)
+}
+
+// Status returns the status code passed to WriteHeader, or 200 if WriteHeader has not been called.
+func (w *BufferingWriter) Status() int {
+	if w.rw.statusCode < 100 {
+		return http.StatusOK
+	}
+	return w.rw.statusCode
+}
+
+// Flush sends the buffered status code and body to the underlying http.ResponseWriter, and flushes it if it
+// implements http.Flusher. Any further data written to w after calling Flush is written to the underlying
+// http.ResponseWriter directly, without buffering, and is flushed by calling Flush again. Since more data may follow,
+// Flush does not set the Content-Length header. Middleware that has buffered the complete body may set it before
+// calling Flush.
+//
+// Flush must be called once the response is complete, otherwise it will not be sent.
+func (w *BufferingWriter) Flush() {
+	w.rw.flush()
+
+	if f, ok := w.rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
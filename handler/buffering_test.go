@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestBufferingWriter(t *testing.T) {
+	is := is.New(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	bw := NewBufferingResponseWriter(w, r)
+
+	bw.Header().Set("X-Test", "testValue")
+	bw.WriteHeader(http.StatusCreated)
+	_, _ = bw.Write([]byte("foo"))
+	_, _ = bw.Write([]byte("bar"))
+
+	is.Equal(bw.Status(), http.StatusCreated)
+	is.Equal(bw.Body(), []byte("foobar"))
+	is.Equal(w.Body.Len(), 0)
+	is.True(!w.Flushed)
+
+	bw.Flush()
+
+	is.Equal(w.Result().StatusCode, http.StatusCreated)
+	is.Equal(w.Result().Header.Get("X-Test"), "testValue")
+	is.Equal(w.Result().Header.Get("Content-Length"), "")
+	is.True(w.Flushed)
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, []byte("foobar"))
+	is.Equal(bw.Body(), []byte("foobar"))
+}
+
+func TestBufferingWriter_ContentLength(t *testing.T) {
+	is := is.New(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	bw := NewBufferingResponseWriter(w, r)
+
+	_, _ = bw.Write([]byte("foobar"))
+	bw.Header().Set("Content-Length", strconv.Itoa(len(bw.Body())))
+	bw.Flush()
+
+	is.Equal(w.Result().Header.Get("Content-Length"), "6")
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, []byte("foobar"))
+}
+
+func TestBufferingWriter_DefaultStatus(t *testing.T) {
+	is := is.New(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	bw := NewBufferingResponseWriter(w, r)
+
+	is.Equal(bw.Status(), http.StatusOK)
+	is.True(bw.Body() == nil)
+}
+
+func TestBufferingWriter_WriteAfterFlush(t *testing.T) {
+	is := is.New(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	bw := NewBufferingResponseWriter(w, r)
+
+	_, _ = bw.Write([]byte("foo"))
+	bw.Flush()
+	_, _ = bw.Write([]byte("bar"))
+	bw.Flush()
+
+	is.Equal(bw.Body(), []byte("foo"))
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, []byte("foobar"))
+}
+
+func TestBufferingWriter_WriteAfterFlush_Server(t *testing.T) {
+	is := is.New(t)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := NewBufferingResponseWriter(w, r)
+		_, _ = bw.Write([]byte("foo"))
+		bw.Flush()
+		_, _ = bw.Write([]byte("bar"))
+		bw.Flush()
+	})
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	res, err := s.Client().Get(s.URL)
+	is.NoErr(err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	b, err := io.ReadAll(res.Body)
+	is.NoErr(err)
+	is.Equal(b, []byte("foobar"))
+}
+
+func TestBufferingWriter_Rewrite(t *testing.T) {
+	is := is.New(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	bw := NewBufferingResponseWriter(httptest.NewRecorder(), r)
+
+	contentHandler([]byte("body"), "X-Test", "testValue").ServeHTTP(bw, r)
+
+	w.Header().Set("X-Test", bw.Header().Get("X-Test"))
+	w.WriteHeader(bw.Status())
+	_, _ = w.Write(append([]byte("new "), bw.Body()...))
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("X-Test"), "testValue")
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, []byte("new body"))
+}
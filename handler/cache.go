@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ETagCache is a bounded cache of entity-tags, evicting the least recently used entries when full.
+// Entries are keyed by a CacheIdentity's Key, and are only considered valid as long as the identity's
+// other fields do not change. An ETagCache is safe for concurrent use.
+//
+// When handlers and functions of this package use an ETagCache, they extend keys by the values of the request
+// headers listed in the response's Vary header, so that entity-tags of different representations of the same
+// resource are cached separately. Responses with a Vary header containing "*" are not cached.
+type ETagCache struct {
+	size    int
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// CacheIdentity identifies a resource's content for the purpose of caching its entity-tag.
+type CacheIdentity struct {
+	// Key is the key of the resource, such as its path.
+	Key string
+
+	// Size is the size of the resource's content.
+	Size int64
+
+	// ModTime is the last modification date of the resource's content.
+	ModTime time.Time
+}
+
+// CacheIdentityFunc returns the CacheIdentity for w, which is r's response.
+// If the function cannot produce an identity, it returns ok==false.
+type CacheIdentityFunc func(w http.ResponseWriter, r *http.Request) (CacheIdentity, bool)
+
+type cacheEntry struct {
+	id   CacheIdentity
+	eTag ETag
+}
+
+// NewETagCache returns a new cache holding at most size entity-tags.
+func NewETagCache(size int) *ETagCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &ETagCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Get returns the entity-tag cached for id. If no entity-tag is cached for id's Key, or if id's other fields
+// do not match the cached identity, it returns ok==false.
+func (c *ETagCache) Get(id CacheIdentity) (ETag, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.get(id)
+}
+
+func (c *ETagCache) get(id CacheIdentity) (ETag, bool) {
+	el, ok := c.entries[id.Key]
+	if !ok {
+		return ETag{}, false
+	}
+
+	ce := el.Value.(*cacheEntry)
+	if ce.id.Size != id.Size || !ce.id.ModTime.Equal(id.ModTime) {
+		c.lru.Remove(el)
+		delete(c.entries, id.Key)
+		return ETag{}, false
+	}
+
+	c.lru.MoveToFront(el)
+	return ce.eTag, true
+}
+
+// Put caches e for id, replacing any entity-tag previously cached for id's Key.
+func (c *ETagCache) Put(id CacheIdentity, e ETag) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.put(id, e)
+}
+
+// CompareAndPut caches e for id, but only if the entity-tag currently cached for id, as returned by Get, is old.
+// If cached is false, e is only cached if no entity-tag is cached for id. CompareAndPut returns whether e has been
+// cached. This allows replacing cached entity-tags depending on their current values atomically.
+func (c *ETagCache) CompareAndPut(id CacheIdentity, old ETag, cached bool, e ETag) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cur, ok := c.get(id)
+	if ok != cached || cur != old {
+		return false
+	}
+
+	c.put(id, e)
+	return true
+}
+
+func (c *ETagCache) put(id CacheIdentity, e ETag) {
+	if el, ok := c.entries[id.Key]; ok {
+		el.Value = &cacheEntry{id: id, eTag: e}
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[id.Key] = c.lru.PushFront(&cacheEntry{id: id, eTag: e})
+
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).id.Key)
+	}
+}
+
+// Len returns the number of entity-tags currently cached.
+func (c *ETagCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.lru.Len()
+}
+
+// varyIdentity returns id with its Key extended by the values of r's headers listed in the Vary header in header,
+// so that entity-tags of different representations are cached separately. If the Vary header contains "*",
+// varyIdentity returns ok==false, and entity-tags should not be cached.
+func varyIdentity(id CacheIdentity, header http.Header, r *http.Request) (CacheIdentity, bool) {
+	var names []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return CacheIdentity{}, false
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		return id, true
+	}
+
+	sort.Strings(names)
+
+	b := strings.Builder{}
+	b.WriteString(id.Key)
+	for _, name := range names {
+		b.WriteString("\x00" + name + ":" + strings.Join(r.Header.Values(name), ","))
+	}
+
+	id.Key = b.String()
+	return id, true
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestETagCache_Hit(t *testing.T) {
+	is := is.New(t)
+
+	c := NewETagCache(10)
+	id := CacheIdentity{Key: "/foo", Size: 3, ModTime: time.Now()}
+	c.Put(id, ETag{Tag: "foo"})
+
+	e, ok := c.Get(id)
+	is.True(ok)
+	is.Equal(e, ETag{Tag: "foo"})
+}
+
+func TestETagCache_CompareAndPut(t *testing.T) {
+	tests := []struct {
+		name     string
+		present  bool
+		old      ETag
+		cached   bool
+		wantOK   bool
+		wantETag ETag
+	}{
+		{
+			name:     "absent",
+			wantOK:   true,
+			wantETag: ETag{Tag: "new"},
+		},
+		{
+			name:     "absent but expected",
+			old:      ETag{Tag: "foo"},
+			cached:   true,
+			wantOK:   false,
+			wantETag: ETag{},
+		},
+		{
+			name:     "equal",
+			present:  true,
+			old:      ETag{Tag: "foo"},
+			cached:   true,
+			wantOK:   true,
+			wantETag: ETag{Tag: "new"},
+		},
+		{
+			name:     "different",
+			present:  true,
+			old:      ETag{Tag: "bar"},
+			cached:   true,
+			wantOK:   false,
+			wantETag: ETag{Tag: "foo"},
+		},
+		{
+			name:     "present but expected absent",
+			present:  true,
+			wantOK:   false,
+			wantETag: ETag{Tag: "foo"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			c := NewETagCache(10)
+			id := CacheIdentity{Key: "/foo"}
+			if test.present {
+				c.Put(id, ETag{Tag: "foo"})
+			}
+
+			is.Equal(c.CompareAndPut(id, test.old, test.cached, ETag{Tag: "new"}), test.wantOK)
+
+			e, _ := c.Get(id)
+			is.Equal(e, test.wantETag)
+		})
+	}
+}
+
+func TestETagCache_Miss(t *testing.T) {
+	is := is.New(t)
+
+	c := NewETagCache(10)
+	c.Put(CacheIdentity{Key: "/foo"}, ETag{Tag: "foo"})
+
+	_, ok := c.Get(CacheIdentity{Key: "/bar"})
+	is.True(!ok)
+}
+
+func TestETagCache_Invalidation(t *testing.T) {
+	tests := []struct {
+		name string
+		id   CacheIdentity
+	}{
+		{
+			name: "mtime",
+			id:   CacheIdentity{Key: "/foo", Size: 3, ModTime: time.Unix(2000, 0)},
+		},
+		{
+			name: "size",
+			id:   CacheIdentity{Key: "/foo", Size: 4, ModTime: time.Unix(1000, 0)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			c := NewETagCache(10)
+			c.Put(CacheIdentity{Key: "/foo", Size: 3, ModTime: time.Unix(1000, 0)}, ETag{Tag: "foo"})
+
+			_, ok := c.Get(test.id)
+			is.True(!ok)
+			is.Equal(c.Len(), 0)
+		})
+	}
+}
+
+func TestETagCache_Eviction(t *testing.T) {
+	is := is.New(t)
+
+	c := NewETagCache(2)
+	c.Put(CacheIdentity{Key: "/1"}, ETag{Tag: "1"})
+	c.Put(CacheIdentity{Key: "/2"}, ETag{Tag: "2"})
+	_, _ = c.Get(CacheIdentity{Key: "/1"})
+	c.Put(CacheIdentity{Key: "/3"}, ETag{Tag: "3"})
+
+	is.Equal(c.Len(), 2)
+	_, ok := c.Get(CacheIdentity{Key: "/1"})
+	is.True(ok)
+	_, ok = c.Get(CacheIdentity{Key: "/2"})
+	is.True(!ok)
+	_, ok = c.Get(CacheIdentity{Key: "/3"})
+	is.True(ok)
+}
+
+func TestETagFromBody_CacheVary(t *testing.T) {
+	is := is.New(t)
+
+	cache := NewETagCache(10)
+	modTime := time.Now()
+	f := ETagFromBody(WithETagCache(cache), WithCacheIdentityFunc(func(w http.ResponseWriter, r *http.Request) (CacheIdentity, bool) {
+		return CacheIdentity{Key: r.URL.Path, Size: int64(len(Body(w))), ModTime: modTime}, true
+	}))
+	h := ETagHandler(f, AfterResponse, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		if r.Header.Get("Accept-Language") == "de" {
+			_, _ = w.Write([]byte("hallo"))
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	eTag := func(lang string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", lang)
+
+		h.ServeHTTP(w, r)
+
+		return w.Result().Header.Get("ETag")
+	}
+
+	is.Equal(eTag("en"), StableETag([]byte("hello")).String())
+	is.Equal(eTag("de"), StableETag([]byte("hallo")).String())
+	is.Equal(eTag("en"), StableETag([]byte("hello")).String())
+	is.Equal(cache.Len(), 2)
+}
+
+func TestVaryIdentity_Star(t *testing.T) {
+	is := is.New(t)
+
+	header := http.Header{}
+	header.Set("Vary", "*")
+	_, ok := varyIdentity(CacheIdentity{Key: "/"}, header, httptest.NewRequest(http.MethodGet, "/", nil))
+	is.True(!ok)
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+// Decision is the decision made by a handler returned by this package about whether to send a response with
+// the 304 Not Modified status code.
+type Decision struct {
+	// NotModified is true if the response is sent with the 304 Not Modified status code.
+	NotModified bool
+
+	// Reason is the reason for the decision.
+	Reason ServeReason
+}
+
+type decisionContextKey struct{}
+
+// DecisionContextKey is the request context key under which IfNoneMatchIfModifiedSinceHandler, IfModifiedSinceHandler,
+// and IfNoneMatchHandler store their decisions. Use DecisionFromContext to read decisions.
+var DecisionContextKey = decisionContextKey{}
+
+type decisionHolder struct {
+	decision Decision
+	decided  bool
+}
+
+// DecisionFromContext returns the decision stored in ctx, which is the context of a request passed to a downstream
+// handler by IfNoneMatchIfModifiedSinceHandler, IfModifiedSinceHandler, or IfNoneMatchHandler.
+//
+// The decision is made when the downstream handler starts writing its response, or when it returns without writing
+// any response. Middleware placed between the handler and the downstream handler can therefore read the decision
+// after calling the downstream handler, for logging purposes, for example. If no decision has been made (yet),
+// or if ctx does not stem from such a handler, DecisionFromContext returns ok==false.
+func DecisionFromContext(ctx context.Context) (Decision, bool) {
+	h, ok := ctx.Value(DecisionContextKey).(*decisionHolder)
+	if !ok || !h.decided {
+		return Decision{}, false
+	}
+	return h.decision, true
+}
+
+// withDecisionHolder returns a shallow copy of r whose context contains a new holder for decisions.
+func withDecisionHolder(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), DecisionContextKey, &decisionHolder{}))
+}
+
+// storeDecision stores the decision in r's context, if it contains a holder for decisions.
+func storeDecision(r *http.Request, statusCode int, reason ServeReason) {
+	h, ok := r.Context().Value(DecisionContextKey).(*decisionHolder)
+	if !ok {
+		return
+	}
+
+	h.decision = Decision{
+		NotModified: statusCode == http.StatusNotModified,
+		Reason:      reason,
+	}
+	h.decided = true
+}
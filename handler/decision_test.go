@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestDecisionFromContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		ifNoneMatch  string
+		wantDecision Decision
+	}{
+		{
+			name:        "not modified",
+			ifNoneMatch: `"foo"`,
+			wantDecision: Decision{
+				NotModified: true,
+				Reason:      ServeReasonNotModified,
+			},
+		},
+		{
+			name:        "mismatch",
+			ifNoneMatch: `"bar"`,
+			wantDecision: Decision{
+				Reason: ServeReasonETagMismatch,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var decision Decision
+			var decided bool
+			logging := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					next.ServeHTTP(w, r)
+					decision, decided = DecisionFromContext(r.Context())
+				})
+			}
+
+			h := IfNoneMatchIfModifiedSinceHandler(false, logging(contentHandler([]byte("body"), "ETag", `"foo"`)))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.True(decided)
+			is.Equal(decision, test.wantDecision)
+		})
+	}
+}
+
+func TestDecisionFromContext_NoDecision(t *testing.T) {
+	is := is.New(t)
+
+	_, ok := DecisionFromContext(context.Background())
+	is.True(!ok)
+
+	var decided bool
+	h := IfNoneMatchIfModifiedSinceHandler(false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, decided = DecisionFromContext(r.Context())
+		_, _ = w.Write([]byte("body"))
+	}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.True(!decided)
+}
@@ -1,3 +1,61 @@
 // Package handler provides middleware for conditional HTTP requests supporting the ETag, Last-Modified,
 // If-Modified-Since, and If-None-Match headers, according to RFC 7232.
+//
+// Handlers and functions returned by this package are safe for concurrent use. A single handler may be constructed
+// once and then serve any number of requests concurrently. Their configuration is not modified after construction,
+// and all per-request state, such as buffered response bodies, is kept in per-request response writers.
+// Shared state such as an ETagCache is synchronized internally. Functions passed to this package, such as ETagFunc
+// or the functions passed to options, may be called concurrently and must be safe for concurrent use themselves.
+//
+// # Reverse Proxies
+//
+// ETagHandler replaces any ETag header set by the downstream handler, unless WithOnlyIfAbsent is used. This supports
+// reverse proxies that transform the upstream response's body: If the downstream handler copies the upstream
+// response, including its ETag header, and the body is hashed using ETagFromBody after the transformation, clients
+// only ever see the local entity-tag. Handlers evaluating If-None-Match headers wrapping ETagHandler, or
+// ConditionalETagHandler, then compare the client's entity-tags with the local entity-tag only, and never with
+// the upstream's.
+//
+// # Compression
+//
+// When using the AfterResponse response mode, the order of ETagHandler and any compression middleware matters.
+// If the compression middleware wraps ETagHandler, the body is hashed before it is encoded, and the compression
+// middleware must remove the Content-Length header set by ETagHandler, and adjust the entity-tag itself.
+// If ETagHandler wraps the compression middleware, the body passed to the ETagFunc is encoded, as indicated by
+// the response's Content-Encoding header. ETagFromBody then produces the following entity-tags for the same content
+// served with and without compression:
+//
+//	                              identity      gzip/deflate
+//	default (strong)              "H(body)"     "H(coding, encoded body)"
+//	WithDecodeContentEncoding     W/"H(body)"   W/"H(body)"
+//
+// Strong entity-tags always differ between content codings, since the bytes sent differ, and the content coding is
+// additionally part of the hashed data. Weak entity-tags produced from decoded bodies are equal for all content
+// codings, since they are only semantically equivalent.
+//
+// Similarly, FileServer serves gzip-compressed siblings of files, such as "app.js.gz" for "app.js", to requests that
+// accept the gzip content coding. The entity-tags of both representations are produced from their respective
+// contents, so they differ, and both responses carry a "Vary: Accept-Encoding" header.
+//
+// # Entity-Tags and Dates
+//
+// If a request contains an If-None-Match header, its If-Modified-Since header is ignored, in accordance with
+// RFC 7232, section 3.3. If a response carries both validators, the entity-tag therefore always takes precedence:
+// a matching entity-tag produces a 304 Not Modified response even if the response has been modified according to
+// the dates, and a non-matching entity-tag produces a full response even if it has not. Setting both the ETag and
+// Last-Modified headers is still recommended, since it lets clients use the strongest validator they support,
+// while clients that only support dates still benefit.
+//
+// Last-Modified headers are always sent in GMT, truncated to whole seconds, regardless of the time zone of the dates
+// produced by the application or reported by the file system. If-Modified-Since headers are accepted in all
+// HTTP-date formats, and compared as absolute points in time.
+//
+// Entity-tags are more precise than dates: A representation that changes twice within the same second keeps its
+// Last-Modified date, so comparing dates would produce a 304 Not Modified response even though the representation
+// has changed. Conversely, a representation whose date changes without changing its content would be sent again.
+// WithIfModifiedSinceStore avoids both by evaluating If-Modified-Since headers using recorded entity-tags. If
+// different entity-tags are recorded for the same request URI and date, the date is ambiguous, and requests carrying
+// that date always receive the full response. The store holds one entry per request URI and date, and evicts the
+// least recently used entries when it is full. Requests carrying dates that are no longer recorded, as well as
+// requests served by other instances of the application, fall back to comparing dates.
 package handler
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type fileServer struct {
+	root  http.FileSystem
+	next  http.Handler
+	cache *ETagCache
+	o     *options
+}
+
+const defaultFileServerCacheSize = 1024
+
+// FileServer returns a handler that serves HTTP requests with the contents of the file system rooted at root,
+// like http.FileServer does. In addition to the Last-Modified header, it sets the ETag header in responses for files,
+// using a strong entity-tag produced from a SHA-256 hash of the file's contents. Conditional and Range requests are
+// evaluated in the same way as by http.FileServer. Redirects, directory listings, and error responses do not carry
+// entity-tags.
+//
+// Entity-tags are cached by file path, size, and last modification date, using the cache configured using
+// WithETagCache, or a cache holding up to 1024 entity-tags otherwise. Gzip-compressed siblings with a ".gz"
+// extension are served to requests that accept them, with their own entity-tags. If WithImmutable is used,
+// 200 OK responses will be marked as immutable.
+func FileServer(root http.FileSystem, opts ...Option) http.Handler {
+	o := newOptions(opts)
+
+	cache := o.eTagCache
+	if cache == nil {
+		cache = NewETagCache(defaultFileServerCacheSize)
+	}
+
+	s := &fileServer{
+		root:  root,
+		cache: cache,
+		o:     o,
+	}
+
+	s.next = http.FileServer(root)
+
+	return s.immutable(s)
+}
+
+func (s *fileServer) immutable(next http.Handler) http.Handler {
+	if !s.o.immutable {
+		return next
+	}
+
+	return headerHandler(
+		func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+			s.o.setImmutable(w, statusCode)
+			return statusCode
+		},
+		AfterHeaders, next)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, info, name, ok := s.resolve(r.URL.Path)
+	if !ok {
+		// let http.FileServer redirect, list directories, or report errors
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	gz, gzInfo, ok := s.open(name + ".gz")
+	if ok {
+		defer func() {
+			_ = gz.Close()
+		}()
+	}
+
+	compressed := ok && !gzInfo.IsDir()
+	if compressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	if compressed && acceptsEncoding(r, "gzip") {
+		s.serveGzip(w, r, name, gz, gzInfo)
+		return
+	}
+
+	s.serveContent(w, r, name, name, f, info)
+}
+
+// resolve opens the file that http.FileServer would serve for the request path upath, which is the directory's
+// index.html file for directories. It returns ok==false if http.FileServer would respond differently, such as
+// by redirecting, by listing a directory, or with an error.
+func (s *fileServer) resolve(upath string) (http.File, os.FileInfo, string, bool) {
+	if strings.HasSuffix(upath, "/index.html") {
+		return nil, nil, "", false
+	}
+
+	name := cleanPath(upath)
+	f, info, ok := s.open(name)
+	if !ok {
+		return nil, nil, "", false
+	}
+
+	trailingSlash := strings.HasSuffix(upath, "/")
+	if !info.IsDir() {
+		if trailingSlash {
+			_ = f.Close()
+			return nil, nil, "", false
+		}
+		return f, info, name, true
+	}
+
+	_ = f.Close()
+	if !trailingSlash {
+		return nil, nil, "", false
+	}
+
+	name = path.Join(name, "index.html")
+	f, info, ok = s.open(name)
+	if !ok {
+		return nil, nil, "", false
+	}
+	if info.IsDir() {
+		_ = f.Close()
+		return nil, nil, "", false
+	}
+
+	return f, info, name, true
+}
+
+// open opens name in s's file system.
+func (s *fileServer) open(name string) (http.File, os.FileInfo, bool) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, false
+	}
+
+	return f, info, true
+}
+
+// serveGzip serves gz, which is the gzip-compressed sibling of the file name.
+func (s *fileServer) serveGzip(w http.ResponseWriter, r *http.Request, name string, gz http.File, info os.FileInfo) {
+	w.Header().Set("Content-Encoding", "gzip")
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+
+	s.serveContent(w, r, name+".gz", name, gz, info)
+}
+
+// serveContent serves the contents of f, which is the file key in s's file system, for the file name.
+func (s *fileServer) serveContent(w http.ResponseWriter, r *http.Request, key string, name string, f http.File,
+	info os.FileInfo) {
+
+	if e, ok := s.eTag(key, f, info, w, r); ok {
+		w.Header().Set("ETag", e.String())
+	}
+
+	http.ServeContent(w, r, path.Base(name), info.ModTime(), f)
+}
+
+func cleanPath(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
+
+// acceptsEncoding returns whether r's Accept-Encoding header accepts the content coding encoding.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	accepted := false
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			coding, q := parseQuality(part)
+			switch {
+			case strings.EqualFold(coding, encoding):
+				// an explicit entry always takes precedence over a wildcard
+				return q > 0
+			case coding == "*":
+				accepted = q > 0
+			}
+		}
+	}
+	return accepted
+}
+
+// parseQuality splits an Accept-Encoding list element into its content coding and its quality value.
+func parseQuality(s string) (string, float64) {
+	parts := strings.Split(s, ";")
+	coding := strings.TrimSpace(parts[0])
+
+	q := 1.0
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if !strings.HasPrefix(strings.ToLower(p), "q=") {
+			continue
+		}
+		if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+			q = f
+		}
+	}
+
+	return coding, q
+}
+
+// eTag returns the entity-tag of f, which is the file name in s's file system, and rewinds f.
+func (s *fileServer) eTag(name string, f http.File, info os.FileInfo, w http.ResponseWriter,
+	r *http.Request) (ETag, bool) {
+
+	id, cacheable := varyIdentity(CacheIdentity{
+		Key:     name,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, w.Header(), r)
+
+	if cacheable {
+		if e, ok := s.cache.Get(id); ok {
+			return e, true
+		}
+	}
+
+	e, err := readerETag(f, s.o)
+	if err != nil {
+		return ETag{}, false
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return ETag{}, false
+	}
+
+	if cacheable {
+		s.cache.Put(id, e)
+	}
+	return e, true
+}
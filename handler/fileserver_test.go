@@ -0,0 +1,433 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestFileServer(t *testing.T) {
+	is := is.New(t)
+
+	fsys := fstest.MapFS{
+		"foo.txt": &fstest.MapFile{
+			Data:    []byte("foo"),
+			ModTime: time.Now(),
+		},
+	}
+	h := FileServer(http.FS(fsys))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("foo")).String())
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, []byte("foo"))
+}
+
+func TestFileServer_IfNoneMatch(t *testing.T) {
+	is := is.New(t)
+
+	fsys := fstest.MapFS{
+		"foo.txt": &fstest.MapFile{
+			Data:    []byte("foo"),
+			ModTime: time.Now(),
+		},
+	}
+	h := FileServer(http.FS(fsys))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+
+	h.ServeHTTP(w, r)
+
+	eTag := w.Result().Header.Get("ETag")
+	is.True(eTag != "")
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+	r.Header.Set("If-None-Match", eTag)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+}
+
+func TestFileServer_LastModified(t *testing.T) {
+	// 2021-06-09 10:18:15.5 UTC, in a local time zone far from UTC
+	modTime := time.Date(2021, 6, 9, 19, 18, 15, 500000000, time.FixedZone("JST", 9*60*60))
+
+	tests := []struct {
+		name       string
+		ims        string
+		wantStatus int
+	}{
+		{
+			name:       "RFC 1123",
+			ims:        "Wed, 09 Jun 2021 10:18:15 GMT",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "RFC 850",
+			ims:        "Wednesday, 09-Jun-21 10:18:15 GMT",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "ANSI C",
+			ims:        "Wed Jun  9 10:18:15 2021",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "later",
+			ims:        "Wed, 09 Jun 2021 19:18:15 GMT",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "modified",
+			ims:        "Wed, 09 Jun 2021 10:18:14 GMT",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			fsys := fstest.MapFS{
+				"foo.txt": &fstest.MapFile{
+					Data:    []byte("foo"),
+					ModTime: modTime,
+				},
+			}
+			h := FileServer(http.FS(fsys))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().Header.Get("Last-Modified"), "Wed, 09 Jun 2021 10:18:15 GMT")
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+			r.Header.Set("If-Modified-Since", test.ims)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestFileServer_Range(t *testing.T) {
+	is := is.New(t)
+
+	fsys := fstest.MapFS{
+		"foo.txt": &fstest.MapFile{
+			Data:    []byte("foobar"),
+			ModTime: time.Now(),
+		},
+	}
+	h := FileServer(http.FS(fsys))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+	r.Header.Set("Range", "bytes=3-")
+	r.Header.Set("If-Range", StableETag([]byte("foobar")).String())
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusPartialContent)
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, []byte("bar"))
+}
+
+func TestFileServer_Cache(t *testing.T) {
+	is := is.New(t)
+
+	modTime := time.Now()
+	fsys := fstest.MapFS{
+		"foo.txt": &fstest.MapFile{
+			Data:    []byte("foo"),
+			ModTime: modTime,
+		},
+	}
+	h := FileServer(http.FS(fsys))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("foo")).String())
+
+	fsys["foo.txt"] = &fstest.MapFile{
+		Data:    []byte("bar"),
+		ModTime: modTime.Add(time.Minute),
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("bar")).String())
+}
+
+func TestFileServer_Dir(t *testing.T) {
+	is := is.New(t)
+
+	fsys := fstest.MapFS{
+		"dir/foo.txt": &fstest.MapFile{
+			Data: []byte("foo"),
+		},
+	}
+	h := FileServer(http.FS(fsys))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/dir/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), "")
+}
+
+func TestFileServer_Index(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{
+			Data:    []byte("<p>index</p>"),
+			ModTime: time.Now(),
+		},
+		"dir/index.html": &fstest.MapFile{
+			Data:    []byte("<p>dir</p>"),
+			ModTime: time.Now(),
+		},
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		wantStatus   int
+		wantETag     string
+		wantLocation string
+	}{
+		{
+			name:       "root",
+			path:       "/",
+			wantStatus: http.StatusOK,
+			wantETag:   StableETag([]byte("<p>index</p>")).String(),
+		},
+		{
+			name:       "dir",
+			path:       "/dir/",
+			wantStatus: http.StatusOK,
+			wantETag:   StableETag([]byte("<p>dir</p>")).String(),
+		},
+		{
+			name:         "index.html",
+			path:         "/index.html",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "./",
+		},
+		{
+			name:         "dir/index.html",
+			path:         "/dir/index.html",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "./",
+		},
+		{
+			name:         "dir without slash",
+			path:         "/dir",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "dir/",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := FileServer(http.FS(fsys))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+			is.Equal(w.Result().Header.Get("Location"), test.wantLocation)
+
+			if test.wantETag == "" {
+				return
+			}
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, test.path, nil)
+			r.Header.Set("If-None-Match", test.wantETag)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotModified)
+		})
+	}
+}
+
+// countingFS is an http.FileSystem that counts calls to Open.
+type countingFS struct {
+	http.FileSystem
+	opens int
+}
+
+func (fs *countingFS) Open(name string) (http.File, error) {
+	fs.opens++
+	return fs.FileSystem.Open(name)
+}
+
+func TestFileServer_OpenOnce(t *testing.T) {
+	is := is.New(t)
+
+	fsys := &countingFS{
+		FileSystem: http.FS(fstest.MapFS{
+			"foo.txt": &fstest.MapFile{
+				Data:    []byte("foo"),
+				ModTime: time.Now(),
+			},
+		}),
+	}
+	h := FileServer(fsys)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Body.String(), "foo")
+	// the file itself, and its compressed sibling
+	is.Equal(fsys.opens, 2)
+}
+
+func TestFileServer_Immutable(t *testing.T) {
+	is := is.New(t)
+
+	fsys := fstest.MapFS{
+		"foo.txt": &fstest.MapFile{
+			Data:    []byte("foo"),
+			ModTime: time.Now(),
+		},
+	}
+	h := FileServer(http.FS(fsys), WithImmutable())
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/foo.txt", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("Cache-Control"), "max-age=31536000, immutable")
+}
+
+func TestFileServer_Gzip(t *testing.T) {
+	gz := bytes.Buffer{}
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte("foo foo foo"))
+	_ = zw.Close()
+
+	fsys := fstest.MapFS{
+		"foo.txt": &fstest.MapFile{
+			Data:    []byte("foo foo foo"),
+			ModTime: time.Now(),
+		},
+		"foo.txt.gz": &fstest.MapFile{
+			Data:    gz.Bytes(),
+			ModTime: time.Now(),
+		},
+		"bar.txt": &fstest.MapFile{
+			Data:    []byte("bar"),
+			ModTime: time.Now(),
+		},
+	}
+
+	tests := []struct {
+		name            string
+		path            string
+		acceptEncoding  string
+		wantETag        string
+		wantEncoding    string
+		wantVary        string
+		wantBody        []byte
+		wantContentType string
+	}{
+		{
+			name:            "identity",
+			path:            "/foo.txt",
+			wantETag:        StableETag([]byte("foo foo foo")).String(),
+			wantVary:        "Accept-Encoding",
+			wantBody:        []byte("foo foo foo"),
+			wantContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:            "gzip",
+			path:            "/foo.txt",
+			acceptEncoding:  "gzip, deflate",
+			wantETag:        StableETag(gz.Bytes()).String(),
+			wantEncoding:    "gzip",
+			wantVary:        "Accept-Encoding",
+			wantBody:        gz.Bytes(),
+			wantContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:            "gzip refused",
+			path:            "/foo.txt",
+			acceptEncoding:  "*, gzip;q=0",
+			wantETag:        StableETag([]byte("foo foo foo")).String(),
+			wantVary:        "Accept-Encoding",
+			wantBody:        []byte("foo foo foo"),
+			wantContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:            "no sibling",
+			path:            "/bar.txt",
+			acceptEncoding:  "gzip",
+			wantETag:        StableETag([]byte("bar")).String(),
+			wantBody:        []byte("bar"),
+			wantContentType: "text/plain; charset=utf-8",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := FileServer(http.FS(fsys))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+			is.Equal(w.Result().Header.Get("Content-Encoding"), test.wantEncoding)
+			is.Equal(w.Result().Header.Get("Vary"), test.wantVary)
+			is.Equal(w.Result().Header.Get("Content-Type"), test.wantContentType)
+			b, _ := io.ReadAll(w.Result().Body)
+			is.Equal(b, test.wantBody)
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			r.Header.Set("If-None-Match", test.wantETag)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotModified)
+		})
+	}
+}
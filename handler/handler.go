@@ -2,12 +2,58 @@ package handler
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// ErrNilHandler is returned by constructors of this package when the next handler passed to them is nil.
+// Constructors that do not return errors panic instead.
+var ErrNilHandler = errors.New("handler: next handler must not be nil")
+
+// ErrDuplicateIfModifiedSince is reported to the function configured using WithErrorFunc when a request contains
+// more than one If-Modified-Since header. In that case, the If-Modified-Since header is ignored.
+var ErrDuplicateIfModifiedSince = errors.New("handler: duplicate If-Modified-Since header")
+
+// ErrConditionHeaderTooLarge is reported to the function configured using WithErrorFunc when a request's
+// If-None-Match or If-Match header is not parsed because it is too large. See WithMaxConditionHeaderBytes.
+var ErrConditionHeaderTooLarge = errors.New("handler: conditional request header too large")
+
+// ErrInvalidLastModified is reported to the function configured using WithErrorFunc when a LastModifiedFunc
+// returns the zero time or a time before January 1, 1970 UTC. See LastModifiedHandler.
+var ErrInvalidLastModified = errors.New("handler: invalid last modification date")
+
+// ErrETagInTrailer is reported to the function configured using WithErrorFunc when a request contains an
+// If-None-Match header, but the downstream handler has set the ETag only as a response trailer. Whether to send
+// the 304 Not Modified status code must be decided before the response body is sent, so entity-tags sent as
+// trailers cannot be used for that, and the full response is sent instead.
+var ErrETagInTrailer = errors.New("handler: ETag only set as response trailer")
+
+// ErrInvalidJSONHeader is wrapped by errors reported to the function configured using WithErrorFunc when a header
+// containing JSON metadata cannot be parsed. See ETagFromJSONHeader.
+var ErrInvalidJSONHeader = errors.New("handler: invalid JSON metadata header")
+
+// ErrInvalidNotModifiedStatus is reported to the function configured using WithErrorFunc when the status code
+// configured using WithNotModifiedStatus is not a 3xx status code. The 304 Not Modified status code is sent instead.
+var ErrInvalidNotModifiedStatus = errors.New("handler: not modified status code must be a 3xx status code")
+
+// ErrInvalidETag is reported to the function configured using WithErrorFunc when an entity-tag produced for
+// a response would not be parsed back from the ETag header unchanged, such as when it contains a double quote.
+// Clients could never revalidate the response using such an entity-tag, so it is not sent.
+var ErrInvalidETag = errors.New("handler: invalid entity-tag")
+
+// ErrUnexpectedPartialContent is reported to the function configured using WithErrorFunc when the downstream
+// handler sends the 206 Partial Content status code in response to a request without a Range header, and
+// WithStrictStatusCheck is used. The response is sent unmodified.
+var ErrUnexpectedPartialContent = errors.New("handler: unexpected 206 Partial Content response")
+
+var unixEpoch = time.Unix(0, 0)
+
 // ETag represents a resource's entity-tag, as specified by RFC 7232, section 2.
 type ETag struct {
 	// Tag is the entity-tag's opaque-tag. The double-quotes required by RFC 7232 should be omitted.
@@ -21,14 +67,112 @@ type ETag struct {
 // If the response mode in use is BeforeHeaders, w will be nil.
 // If the response mode in use is AfterResponse, w's body can be obtained using Body.
 // If the function cannot produce an entity-tag, it returns ok==false.
+//
+// If a resource has multiple representations that are selected using content negotiation, the function should
+// return the entity-tag of the representation selected for r, so that each representation has a distinct
+// entity-tag. Conditional requests are then evaluated against the selected representation's entity-tag.
+// Responses should also contain a Vary header listing the request headers used for content negotiation,
+// so that caches store the representations separately.
 type ETagFunc func(w http.ResponseWriter, r *http.Request) (ETag, bool)
 
+// ETagFuncE works like ETagFunc, but may additionally return an error if producing the entity-tag failed,
+// for example because a database could not be queried. ETagFuncFromE can be used to adapt it to an ETagFunc.
+type ETagFuncE func(w http.ResponseWriter, r *http.Request) (ETag, bool, error)
+
 // LastModifiedFunc returns the last modification date for w, which is r's response.
 // If the response mode in use is BeforeHeaders, w will be nil.
 // If the response mode in use is AfterResponse, w's body can be obtained using Body.
 // If the function cannot produce a last modification date, it returns ok==false.
 type LastModifiedFunc func(w http.ResponseWriter, r *http.Request) (time.Time, bool)
 
+// ETagFuncFromE returns an ETagFunc that calls f. If f returns an error, the returned function returns ok==false,
+// and the error is passed to the function configured using WithErrorFunc, if any.
+//
+// If WithFailOnError is used, an error additionally causes the response to be sent with the
+// 500 Internal Server Error status code, discarding the response body produced by the downstream handler,
+// as well as its Content-Length and Content-Type headers.
+// This is only supported if the returned function is used with the AfterHeaders or AfterResponse response modes.
+func ETagFuncFromE(f ETagFuncE, opts ...Option) ETagFunc {
+	o := newOptions(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		e, ok, err := f(w, r)
+		if err != nil {
+			o.reportError(err, r)
+
+			if rw, isRW := w.(*responseWriter); isRW && o.failOnError {
+				rw.failed = true
+			}
+
+			return ETag{}, false
+		}
+
+		return e, ok
+	}
+}
+
+// ETagFromJSONHeader returns an ETagFunc that produces entity-tags from the response header name, which must contain
+// a JSON object such as {"etag":"abc","weak":false}. This can be used with services that propagate validators in
+// metadata headers. The etag field contains the entity-tag's Tag, and the optional weak field determines whether
+// the entity-tag is weak.
+//
+// If the header is not set, the function returns ok==false. If the header cannot be parsed, or does not contain
+// an entity-tag, the function returns ok==false as well, and an error wrapping ErrInvalidJSONHeader is passed to
+// the function configured using WithErrorFunc, if any.
+func ETagFromJSONHeader(name string, opts ...Option) ETagFunc {
+	o := newOptions(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		v := w.Header().Get(name)
+		if v == "" {
+			return ETag{}, false
+		}
+
+		var meta struct {
+			ETag string `json:"etag"`
+			Weak bool   `json:"weak"`
+		}
+		if err := json.Unmarshal([]byte(v), &meta); err != nil {
+			o.reportError(fmt.Errorf("%w: %s: %v", ErrInvalidJSONHeader, name, err), r)
+			return ETag{}, false
+		}
+
+		if meta.ETag == "" {
+			o.reportError(fmt.Errorf("%w: %s: no entity-tag", ErrInvalidJSONHeader, name), r)
+			return ETag{}, false
+		}
+
+		return ETag{
+			Tag:  meta.ETag,
+			Weak: meta.Weak,
+		}, true
+	}
+}
+
+// LastModifiedMax returns a LastModifiedFunc that calls all of funcs, and returns the latest last modification
+// date returned by any of them. Functions that cannot produce a last modification date are skipped.
+// If none of funcs can produce a last modification date, the returned function returns ok==false.
+func LastModifiedMax(funcs ...LastModifiedFunc) LastModifiedFunc {
+	return func(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+		var max time.Time
+		found := false
+
+		for _, f := range funcs {
+			lm, ok := f(w, r)
+			if !ok {
+				continue
+			}
+
+			if !found || lm.After(max) {
+				max = lm
+				found = true
+			}
+		}
+
+		return max, found
+	}
+}
+
 // ResponseMode determines the amount of response data available when calling ETagFunc or LastModifiedFunc.
 type ResponseMode int
 
@@ -44,10 +188,91 @@ const (
 	// been produced.
 	//
 	// Note that using AfterResponse will cause handlers returned by this package to buffer the response produced
-	// by a downstream handler entirely in memory, which may not be desirable.
+	// by a downstream handler entirely in memory, which may not be desirable. Since the body's length is known
+	// after buffering, the Content-Length header will be set accordingly, replacing any Transfer-Encoding header
+	// set by the downstream handler. If multiple handlers using AfterResponse are chained directly, they share
+	// a single buffer, unless WithMaxBufferSize or WithContentTypeFilter are used.
 	AfterResponse
+
+	// HeadersReady is the response mode used to call functions after response headers have been produced,
+	// like AfterHeaders. Unlike AfterHeaders, functions are called as soon as the downstream handler calls
+	// WriteHeader explicitly, rather than when it starts writing the body. This is useful for downstream handlers
+	// that call WriteHeader, and then block before streaming the body. The body is not buffered.
+	HeadersReady
 )
 
+// ServeReason is the reason why a handler returned by this package has decided whether to return the
+// 304 Not Modified status code in a response.
+type ServeReason int
+
+const (
+	// ServeReasonNotModified indicates that the response has been served with the 304 Not Modified status code.
+	ServeReasonNotModified = ServeReason(iota)
+
+	// ServeReasonNoConditionalHeaders indicates that the request did not contain any conditional request headers
+	// evaluated by the handler.
+	ServeReasonNoConditionalHeaders
+
+	// ServeReasonNoValidator indicates that the response did not carry the validator required to evaluate the
+	// request's conditional request headers, such as an ETag header for If-None-Match.
+	ServeReasonNoValidator
+
+	// ServeReasonETagMismatch indicates that none of the entity-tags in the request's If-None-Match header
+	// matched the entity-tag of the response.
+	ServeReasonETagMismatch
+
+	// ServeReasonModified indicates that the response's Last-Modified date is later than the request's
+	// If-Modified-Since date.
+	ServeReasonModified
+
+	// ServeReasonParseError indicates that a conditional request header or a validator of the response
+	// could not be parsed.
+	ServeReasonParseError
+
+	// ServeReasonIneligibleStatus indicates that the response's status code is not eligible for conditional
+	// request evaluation.
+	ServeReasonIneligibleStatus
+
+	// ServeReasonWeakRange indicates that a range request has not been evaluated because the response
+	// does not carry a strong entity-tag. See WithStrictRangeValidation.
+	ServeReasonWeakRange
+
+	// ServeReasonValidatorMismatch indicates that a custom validator did not match. See RegisterValidator.
+	ServeReasonValidatorMismatch
+
+	// ServeReasonPreconditionFailed indicates that the request's If-None-Match header matched, but the
+	// request method is not eligible for the 304 Not Modified status code, so that the response has been
+	// sent with the 412 Precondition Failed status code instead. This is only done by PrecomputeHandler,
+	// which evaluates conditional request headers before calling the downstream handler. See WithEligibleMethods.
+	ServeReasonPreconditionFailed
+
+	// ServeReasonIneligibleMethod indicates that the request's conditional request headers have not been applied
+	// to the response because the request method is not eligible. See WithEligibleMethods.
+	ServeReasonIneligibleMethod
+)
+
+var serveReasonNames = [...]string{
+	ServeReasonNotModified:          "not modified",
+	ServeReasonNoConditionalHeaders: "no conditional headers",
+	ServeReasonNoValidator:          "no validator",
+	ServeReasonETagMismatch:         "entity-tag mismatch",
+	ServeReasonModified:             "modified",
+	ServeReasonParseError:           "parse error",
+	ServeReasonIneligibleStatus:     "ineligible status",
+	ServeReasonWeakRange:            "weak range",
+	ServeReasonValidatorMismatch:    "validator mismatch",
+	ServeReasonPreconditionFailed:   "precondition failed",
+	ServeReasonIneligibleMethod:     "ineligible method",
+}
+
+// String implements fmt.Stringer.
+func (s ServeReason) String() string {
+	if s < 0 || int(s) >= len(serveReasonNames) {
+		return "ServeReason(" + strconv.Itoa(int(s)) + ")"
+	}
+	return serveReasonNames[s]
+}
+
 type responseWriter struct {
 	w                 http.ResponseWriter
 	r                 *http.Request
@@ -56,66 +281,170 @@ type responseWriter struct {
 	beforeWriteHeader beforeWriteHeaderFunc
 	bufferBody        bool
 	headerWritten     bool
+	discardBody       bool
+	discardedBytes    int64
+	writtenStatusCode int
+	contentLength     int64
+	failed            bool
+	flushed           bool
+	maxBufferSize     int
+	bufferAbandoned   bool
+	contentTypeFilter func(string) bool
+	bodyAccessed      bool
+	debugBody         string
+	eagerHeader       bool
+	notModifiedStatus int
+	bufferShared      bool
+	bufferedSize      int
+	initialBufferCap  int
+	streamAfterFlush  bool
 }
 
+// maxContentLengthBufferCap is the maximum capacity that body buffers are pre-sized to according to
+// the Content-Length header.
+const maxContentLengthBufferCap = 64 * 1024
+
 type beforeWriteHeaderFunc func(int) int
 
+type afterResponseFunc func(*responseWriter, *http.Request)
+
 type headerFunc func(http.ResponseWriter, *http.Request, int) int
 
+type matchFunc func(http.ResponseWriter, *http.Request, int) (int, ServeReason)
+
 // ETagHandler returns a handler that uses f to set the ETag header in responses.
 // If rm is BeforeHeaders, the response passed to f will be nil.
-// If rm is AfterHeaders, the response passed to f will contain the headers set by next.
+// If rm is AfterHeaders or HeadersReady, the response passed to f will contain the headers set by next.
 // If rm is AfterResponse, the response passed to f will contain both headers and body produced by next.
 // If f cannot produce an entity-tag (ok result is false), then the ETag header will not be set.
-func ETagHandler(f ETagFunc, rm ResponseMode, next http.Handler) http.Handler {
-	return headerHandler(
-		func(w http.ResponseWriter, r *http.Request, statusCode int) int {
-			e, ok := f(w, r)
-			if !ok {
+// If rm is AfterResponse, the maximum size of the buffered body can be configured using WithMaxBufferSize.
+//
+// If a fingerprint function is configured using WithFingerprint, f may be nil; otherwise, f takes precedence.
+// Unless WithOnlyIfAbsent is used, any ETag header set by next is replaced. If WithImmutable is used and rm is not
+// BeforeHeaders, 200 OK responses with a strong entity-tag will be marked as immutable. See the package documentation
+// for using ETagHandler with reverse proxies and compression middleware.
+func ETagHandler(f ETagFunc, rm ResponseMode, next http.Handler, opts ...Option) http.Handler {
+	o := newOptions(opts)
+
+	if rm == BeforeHeaders && o.strictRangeValidation && next != nil {
+		// the status code is only known once next writes its response
+		next = headerHandlerOpts(
+			func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+				o.removeWeakPartialETag(w, statusCode)
 				return statusCode
-			}
-			w.Header().Set("ETag", e.String())
+			},
+			nil, AfterHeaders, next, o)
+	}
+
+	return headerHandlerOpts(
+		func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+			setETag(f, w, r, statusCode, o)
 			return statusCode
 		},
-		rm, next)
+		nil, rm, next, o)
+}
+
+// ConditionalETagHandler combines ETagHandler and IfNoneMatchIfModifiedSinceHandler into a single handler.
+// It uses f to set the ETag header in responses in the same way as ETagHandler, and then evaluates the request's
+// conditional request headers against the response in the same way as IfNoneMatchIfModifiedSinceHandler.
+//
+// Since only a single response writer is used, the response body is buffered at most once when rm is AfterResponse.
+// If the entity-tag produced from the buffered body matches the request's If-None-Match header, the buffered body
+// is discarded instead of being sent, and the 304 Not Modified status code is sent. Note that this only saves
+// network traffic: the downstream handler still produces the entire body, and f still hashes it, if it uses the body.
+// To avoid calling the downstream handler at all, use PrecomputeHandler instead.
+//
+// If rm is BeforeHeaders, AfterHeaders is used instead, since conditional request headers can only be evaluated
+// once the downstream handler has started writing its response.
+func ConditionalETagHandler(f ETagFunc, rm ResponseMode, weakETagComparison bool, next http.Handler,
+	opts ...Option) http.Handler {
+
+	o := newOptions(opts)
+
+	if rm == BeforeHeaders {
+		rm = AfterHeaders
+	}
+
+	return notModifiedHandler(
+		func(w http.ResponseWriter, r *http.Request, statusCode int) (int, ServeReason) {
+			setETag(f, w, r, statusCode, o)
+			return matchIfNoneMatchIfModifiedSince(w, r, o, o.weakComparison(r, weakETagComparison), statusCode)
+		},
+		rm, next, o)
+}
+
+// setETag uses f to set the ETag header in w, according to o.
+func setETag(f ETagFunc, w http.ResponseWriter, r *http.Request, statusCode int, o *options) {
+	if !o.onlyIfAbsent || w.Header().Get(o.eTagHeader()) == "" {
+		if e, ok := o.eTag(f, w, r); ok {
+			w.Header().Set(o.eTagHeader(), o.formatETag(e))
+		}
+	}
+
+	o.removeWeakPartialETag(w, statusCode)
+	o.setImmutable(w, statusCode)
 }
 
 // LastModifiedHandler returns a handler that uses f to set the Last-Modified header in responses.
 // If rm is BeforeHeaders, the response passed to f will be nil.
-// If rm is AfterHeaders, the response passed to f will contain the headers set by next.
+// If rm is AfterHeaders or HeadersReady, the response passed to f will contain the headers set by next.
 // If rm is AfterResponse, the response passed to f will contain both headers and body produced by next.
 // If f cannot produce a last modification date (ok result is false), then the Last-Modification header
 // will not be set.
-func LastModifiedHandler(f LastModifiedFunc, rm ResponseMode, next http.Handler) (http.Handler, error) {
+// If f returns the zero time or a time before January 1, 1970 UTC, which cannot be represented meaningfully as
+// an HTTP date, the Last-Modified header will not be set either, and ErrInvalidLastModified is passed to the
+// function configured using WithErrorFunc, if any.
+// If next is nil, LastModifiedHandler returns ErrNilHandler.
+func LastModifiedHandler(f LastModifiedFunc, rm ResponseMode, next http.Handler, opts ...Option) (http.Handler, error) {
+	if next == nil {
+		return nil, ErrNilHandler
+	}
+
 	loc, err := time.LoadLocation("GMT")
 	if err != nil {
 		return nil, err
 	}
 
-	return headerHandler(
+	o := newOptions(opts)
+
+	return headerHandlerOpts(
 		func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+			if o.onlyIfAbsent && w.Header().Get(o.lastModifiedHeader()) != "" {
+				return statusCode
+			}
+
 			lm, ok := f(w, r)
 			if !ok {
 				return statusCode
 			}
-			w.Header().Set("Last-Modified", lm.In(loc).Format(time.RFC1123))
+			if lm.Before(unixEpoch) {
+				o.reportError(ErrInvalidLastModified, r)
+				return statusCode
+			}
+			w.Header().Set(o.lastModifiedHeader(), lm.In(loc).Format(time.RFC1123))
 			return statusCode
 		},
-		rm, next), nil
+		nil, rm, next, o), nil
 }
 
 // LastModifiedHandlerConstant returns a handler that sets the Last-Modification header in responses to t.
-func LastModifiedHandlerConstant(t time.Time, next http.Handler) (http.Handler, error) {
+// If next is nil, LastModifiedHandlerConstant returns ErrNilHandler.
+func LastModifiedHandlerConstant(t time.Time, next http.Handler, opts ...Option) (http.Handler, error) {
+	if next == nil {
+		return nil, ErrNilHandler
+	}
+
 	loc, err := time.LoadLocation("GMT")
 	if err != nil {
 		return nil, err
 	}
 
 	ts := t.In(loc).Format(time.RFC1123)
+	o := newOptions(opts)
 
 	return headerHandler(
 		func(w http.ResponseWriter, r *http.Request, statusCode int) int {
-			w.Header().Set("Last-Modified", ts)
+			w.Header().Set(o.lastModifiedHeader(), ts)
 			return statusCode
 		},
 		BeforeHeaders, next), nil
@@ -128,93 +457,455 @@ func LastModifiedHandlerConstant(t time.Time, next http.Handler) (http.Handler,
 //
 // If the request contains an If-None-Match header, the request's If-Modified-Since header is ignored,
 // in accordance with RFC 7232, section 3.3.
-// If weakETagComparison==true, entity-tags are compared weakly.
+// If weakETagComparison==true, entity-tags are compared weakly. The comparison strength can be determined
+// per request using WithWeakComparisonFunc.
 // If neither entity-tags nor last modification date checks are successful, the response will not be modified.
-func IfNoneMatchIfModifiedSinceHandler(weakETagComparison bool, next http.Handler) http.Handler {
-	return headerHandler(
+//
+// Only responses with the 200 OK or 206 Partial Content status codes to GET and HEAD requests are evaluated (see
+// WithEligibleStatusCodes and WithEligibleMethods). Since evaluation happens after next has performed the request,
+// this handler does not protect unsafe methods such as PUT; use IfMatchHandler or PrecomputeHandler instead.
+// 304 Not Modified responses never carry a body, nor the Content-Type and Content-Length headers.
+func IfNoneMatchIfModifiedSinceHandler(weakETagComparison bool, next http.Handler, opts ...Option) http.Handler {
+	o := newOptions(opts)
+
+	return notModifiedHandler(
+		func(w http.ResponseWriter, r *http.Request, statusCode int) (int, ServeReason) {
+			return matchIfNoneMatchIfModifiedSince(w, r, o, o.weakComparison(r, weakETagComparison), statusCode)
+		},
+		AfterHeaders, next, o)
+}
+
+// IfModifiedSinceHandler returns a handler that returns the 304 Not Modified status code in responses
+// if the response's Last-Modified header is not later than the request's If-Modified-Since header.
+// Unlike IfNoneMatchIfModifiedSinceHandler, it does not evaluate the request's If-None-Match header,
+// which avoids any entity-tag parsing for resources that only carry last modification dates.
+//
+// If the request also contains a Range header, the 304 Not Modified status code is still sent if the response
+// has not been modified, since the requested range is moot then, in accordance with RFC 7232, section 6.
+// Otherwise, the partial content produced by next is sent. Use WithStrictRangeValidation to always send
+// the response produced by next for Range requests instead.
+//
+// The same options as for IfNoneMatchIfModifiedSinceHandler apply, except for those concerning entity-tags,
+// such as WithIfModifiedSinceStore.
+func IfModifiedSinceHandler(next http.Handler, opts ...Option) http.Handler {
+	o := newOptions(opts)
+
+	return notModifiedHandler(
+		func(w http.ResponseWriter, r *http.Request, statusCode int) (int, ServeReason) {
+			if reason, ok := o.evaluable(w, r, statusCode); !ok {
+				return statusCode, reason
+			}
+			return tryMatchLastModified(w, r, o, statusCode)
+		},
+		AfterHeaders, next, o)
+}
+
+// IfNoneMatchHandler returns a handler that returns the 304 Not Modified status code in responses
+// if the entity-tag in the request's If-None-Match header matches the entity-tag of the response's ETag header.
+// Unlike IfNoneMatchIfModifiedSinceHandler, it does not evaluate the request's If-Modified-Since header,
+// which avoids any date parsing for resources that only carry entity-tags.
+// If weakETagComparison==true, entity-tags are compared weakly.
+//
+// The same options as for IfNoneMatchIfModifiedSinceHandler apply.
+func IfNoneMatchHandler(weakETagComparison bool, next http.Handler, opts ...Option) http.Handler {
+	o := newOptions(opts)
+
+	return notModifiedHandler(
+		func(w http.ResponseWriter, r *http.Request, statusCode int) (int, ServeReason) {
+			if reason, ok := o.evaluable(w, r, statusCode); !ok {
+				return statusCode, reason
+			}
+			if statusCode, reason, ok := tryMatchETag(w, r, o, o.weakComparison(r, weakETagComparison), statusCode); ok {
+				return statusCode, reason
+			}
+			return statusCode, ServeReasonNoConditionalHeaders
+		},
+		AfterHeaders, next, o)
+}
+
+// notModifiedHandler returns a handler that uses match to determine whether to return the 304 Not Modified
+// status code in responses, applying the options common to all such handlers.
+func notModifiedHandler(match matchFunc, rm ResponseMode, next http.Handler, o *options) http.Handler {
+	h := headerHandlerOpts(
 		func(w http.ResponseWriter, r *http.Request, statusCode int) int {
-			if statusCode, ok := tryMatchETag(w, r, weakETagComparison, statusCode); ok {
+			o.checkStatus(statusCode, r)
+			newStatusCode, reason := match(w, r, statusCode)
+			if newStatusCode == http.StatusPreconditionFailed {
+				// next has already performed the request, so failing the precondition cannot prevent that anymore
+				newStatusCode, reason = statusCode, ServeReasonIneligibleMethod
+			}
+			statusCode = newStatusCode
+
+			o.reportServeReason(reason, r)
+			storeDecision(r, statusCode, reason)
+			if statusCode != http.StatusNotModified {
+				for _, f := range o.fullResponseFuncs {
+					f(w, r)
+				}
 				return statusCode
 			}
-			return tryMatchLastModified(w, r, statusCode)
+
+			o.setDebug304Body(w, r)
+			o.setContentLocation(w)
+			return o.notModifiedStatusCode(r)
+		},
+		func(rw *responseWriter, r *http.Request) {
+			o.reportBytesSaved(rw, r)
+			o.writeDebug304Body(rw)
+			o.reportTrailerETag(rw, r)
 		},
-		AfterHeaders, next)
+		rm, next, o)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, withDecisionHolder(r))
+	})
+}
+
+func matchIfNoneMatchIfModifiedSince(w http.ResponseWriter, r *http.Request, o *options, weakETagComparison bool,
+	statusCode int) (int, ServeReason) {
+	if reason, ok := o.evaluable(w, r, statusCode); !ok {
+		return statusCode, reason
+	}
+
+	if statusCode, reason, ok := tryMatchETag(w, r, o, weakETagComparison, statusCode); ok {
+		return statusCode, reason
+	}
+	if statusCode, reason, ok := tryMatchValidators(w, r, o, statusCode); ok {
+		return statusCode, reason
+	}
+	if statusCode, reason, ok := tryMatchStoredETag(w, r, o, weakETagComparison, statusCode); ok {
+		return statusCode, reason
+	}
+	return tryMatchLastModified(w, r, o, statusCode)
+}
+
+// tryMatchStoredETag evaluates the request's If-Modified-Since header by comparing the entity-tag previously
+// served together with that date, as recorded in the store configured using WithIfModifiedSinceStore,
+// with the response's entity-tag. It returns ok==false if the store cannot be used for the request.
+func tryMatchStoredETag(w http.ResponseWriter, r *http.Request, o *options, weakETagComparison bool,
+	statusCode int) (int, ServeReason, bool) {
+
+	if o.ifModifiedSinceStore == nil {
+		return 0, ServeReasonNoConditionalHeaders, false
+	}
+	defer o.storeValidators(w, r)
+
+	ims := o.requestHeader(r, "If-Modified-Since")
+	if ims == "" || !o.eligibleMethod(r.Method) || len(r.Header.Values("If-Modified-Since")) > 1 {
+		return 0, ServeReasonNoConditionalHeaders, false
+	}
+
+	imsT, err := parseHTTPTime(ims)
+	if err != nil {
+		return 0, ServeReasonParseError, false
+	}
+
+	e, ok := o.responseETag(w)
+	if !ok {
+		return 0, ServeReasonNoValidator, false
+	}
+
+	stored, ok := o.storedETag(w, r, imsT)
+	switch {
+	case !ok:
+		return 0, ServeReasonNoValidator, false
+	case stored.Tag == "":
+		// different representations have been served with the same date
+		return statusCode, ServeReasonModified, true
+	case stored.equal(e, weakETagComparison):
+		return http.StatusNotModified, ServeReasonNotModified, true
+	default:
+		return statusCode, ServeReasonETagMismatch, true
+	}
 }
 
-func tryMatchETag(w http.ResponseWriter, r *http.Request, weakETagComparison bool, statusCode int) (int, bool) {
-	inm := r.Header.Get("If-None-Match")
+// IfMatchHandler returns a handler that evaluates the request's If-Match header before calling next, in accordance
+// with RFC 7232, section 3.1. The resource's current entity-tag is produced by calling f, in the same way as when
+// using the BeforeHeaders response mode. If none of the entity-tags listed in the If-Match header match it,
+// the 412 Precondition Failed status code is sent without calling next, so that unsafe methods such as PUT are not
+// performed on a stale representation. Entity-tags are always compared strongly. An If-Match header value of "*"
+// matches if f produces an entity-tag, that is, if a current representation exists.
+//
+// If request methods are configured using WithRequirePrecondition, requests using those methods that contain
+// neither an If-Match nor an If-Unmodified-Since header are answered with the 428 Precondition Required status
+// code, without calling next.
+//
+// Requests for paths excluded using WithPathPrefixes are passed to next directly. WithContentTypeFilter does not
+// apply, since the If-Match header is evaluated before any response exists.
+func IfMatchHandler(f ETagFunc, next http.Handler, opts ...Option) http.Handler {
+	if next == nil {
+		panic(ErrNilHandler)
+	}
+
+	o := newOptions(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !o.pathIncluded(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r, err := o.readRequestTrailers(r, "If-Match")
+		if err != nil {
+			o.reportError(err, r)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case o.preconditionRequired(r):
+			http.Error(w, http.StatusText(http.StatusPreconditionRequired), http.StatusPreconditionRequired)
+		case ifMatchFailed(f, r, o):
+			w.WriteHeader(http.StatusPreconditionFailed)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// ifMatchFailed returns whether r contains an If-Match header that does not match the current entity-tag
+// produced by f.
+func ifMatchFailed(f ETagFunc, r *http.Request, o *options) bool {
+	if o.requestHeaderList(r, "If-Match") == "" {
+		return false
+	}
+
+	w := headerWriter{}
+	if e, ok := o.eTag(f, nil, r); ok {
+		w.Header().Set(o.eTagHeader(), o.formatETag(e))
+	}
+
+	return tryMatchIfMatch(w, r, o, http.StatusOK) == http.StatusPreconditionFailed
+}
+
+func tryMatchIfMatch(w http.ResponseWriter, r *http.Request, o *options, statusCode int) int {
+	im := o.requestHeaderList(r, "If-Match")
+	if im == "" || statusCode < 200 || statusCode > 299 {
+		return statusCode
+	}
+
+	eTag := o.responseETagValue(w)
+	if strings.TrimSpace(im) == "*" {
+		if eTag == "" {
+			return http.StatusPreconditionFailed
+		}
+		return statusCode
+	}
+
+	e, ok := o.parseETag(eTag)
+	if !ok {
+		return http.StatusPreconditionFailed
+	}
+
+	if o.conditionHeaderTooLarge(im, r) {
+		return http.StatusPreconditionFailed
+	}
+
+	imEs, ok := parseETagList(im, o)
+	if !ok {
+		return http.StatusPreconditionFailed
+	}
+
+	if e.matchAny(imEs, false) {
+		return statusCode
+	}
+
+	return http.StatusPreconditionFailed
+}
+
+func tryMatchETag(w http.ResponseWriter, r *http.Request, o *options, weakETagComparison bool,
+	statusCode int) (int, ServeReason, bool) {
+	inm := o.requestHeaderList(r, "If-None-Match")
 	if inm == "" {
-		return 0, false
+		return 0, ServeReasonNoConditionalHeaders, false
 	}
 
-	eTag := w.Header().Get("ETag")
+	eTag := o.responseETagValue(w)
 	if eTag == "" {
-		return statusCode, true
+		return statusCode, ServeReasonNoValidator, true
+	}
+
+	if inm == eTag && o.eligibleMethod(r.Method) && quickMatch(eTag, weakETagComparison, o) {
+		return http.StatusNotModified, ServeReasonNotModified, true
+	}
+
+	return matchETagList(w, r, o, inm, eTag, weakETagComparison, statusCode)
+}
+
+// matchETagList matches the response's entity-tag eTag against the entity-tag list inm from the request's
+// If-None-Match header, after parsing both.
+func matchETagList(w http.ResponseWriter, r *http.Request, o *options, inm string, eTag string,
+	weakETagComparison bool, statusCode int) (int, ServeReason, bool) {
+
+	if o.conditionHeaderTooLarge(inm, r) {
+		return statusCode, ServeReasonParseError, true
 	}
 
-	inmE, ok := eTagFromString(inm)
+	inmEs, ok := parseETagList(inm, o)
 	if !ok {
-		return statusCode, true
+		return statusCode, ServeReasonParseError, true
 	}
 
-	e, ok := eTagFromString(eTag)
+	e, ok := o.parseETag(eTag)
 	if !ok {
-		return statusCode, true
+		return statusCode, ServeReasonParseError, true
+	}
+
+	if o.matchAnySuffixed(e, inmEs, weakETagComparison) {
+		if !o.eligibleMethod(r.Method) {
+			// RFC 7232, section 3.2
+			return http.StatusPreconditionFailed, ServeReasonPreconditionFailed, true
+		}
+
+		o.setWeakMatchWarning(w, e, inmEs)
+		return http.StatusNotModified, ServeReasonNotModified, true
 	}
 
-	if inmE.equal(e, weakETagComparison) {
-		return http.StatusNotModified, true
+	return statusCode, ServeReasonETagMismatch, true
+}
+
+// quickMatch returns whether eTag, which is identical to the request's If-None-Match header, can be considered
+// a match without parsing. This is the case if eTag is a single well-formed entity-tag, and if comparing it with
+// itself matches under weakETagComparison without any further side effects. Otherwise, both headers must be parsed.
+func quickMatch(eTag string, weakETagComparison bool, o *options) bool {
+	tag := eTag
+	if strings.HasPrefix(tag, "W/") {
+		if !weakETagComparison || o.weakMatchWarning != "" {
+			return false
+		}
+		tag = tag[2:]
+	}
+
+	return len(tag) >= 2 && tag[0] == '"' && strings.IndexByte(tag[1:], '"') == len(tag)-2
+}
+
+func tryMatchValidators(w http.ResponseWriter, r *http.Request, o *options, statusCode int) (int, ServeReason, bool) {
+	for _, v := range o.validators {
+		reqValue := o.requestHeader(r, v.reqHeader)
+		if reqValue == "" {
+			continue
+		}
+
+		respValue := w.Header().Get(v.respHeader)
+		if respValue == "" {
+			return statusCode, ServeReasonNoValidator, true
+		}
+
+		if v.cmp(reqValue, respValue) {
+			return http.StatusNotModified, ServeReasonNotModified, true
+		}
+
+		return statusCode, ServeReasonValidatorMismatch, true
 	}
 
-	return statusCode, true
+	return 0, ServeReasonNoConditionalHeaders, false
 }
 
-func tryMatchLastModified(w http.ResponseWriter, r *http.Request, statusCode int) int {
-	ims := r.Header.Get("If-Modified-Since")
-	lm := w.Header().Get("Last-Modified")
+func tryMatchLastModified(w http.ResponseWriter, r *http.Request, o *options, statusCode int) (int, ServeReason) {
+	ims := o.requestHeader(r, "If-Modified-Since")
+	lm := w.Header().Get(o.lastModifiedHeader())
 	switch {
-	case ims == "", lm == "":
-		return statusCode
+	case ims == "":
+		return statusCode, ServeReasonNoConditionalHeaders
+	case !o.eligibleMethod(r.Method):
+		// RFC 7232, section 3.3
+		return statusCode, ServeReasonIneligibleMethod
+	case len(r.Header.Values("If-Modified-Since")) > 1:
+		// RFC 7232, section 3.3: multiple dates make the header field invalid
+		o.reportError(ErrDuplicateIfModifiedSince, r)
+		return statusCode, ServeReasonParseError
+	case lm == "":
+		return statusCode, ServeReasonNoValidator
 	case ims == lm:
-		return http.StatusNotModified
+		return http.StatusNotModified, ServeReasonNotModified
 	}
 
-	imsT, err := time.Parse(time.RFC1123, ims)
+	modified, err := o.modifiedSince(lm, ims)
+	switch {
+	case err != nil:
+		return statusCode, ServeReasonParseError
+	case !modified:
+		return http.StatusNotModified, ServeReasonNotModified
+	default:
+		return statusCode, ServeReasonModified
+	}
+}
+
+// modifiedSince returns whether the HTTP-date lm is after the HTTP-date ims.
+func (o *options) modifiedSince(lm string, ims string) (bool, error) {
+	imsT, err := parseHTTPTime(ims)
 	if err != nil {
-		return statusCode
+		return false, err
 	}
 
-	lmT, err := time.Parse(time.RFC1123, lm)
+	lmT, err := parseHTTPTime(lm)
 	if err != nil {
-		return statusCode
+		return false, err
 	}
 
-	if lmT.Before(imsT) || lmT.Equal(imsT) {
-		return http.StatusNotModified
+	if o.minuteGranularity {
+		imsT, lmT = imsT.Truncate(time.Minute), lmT.Truncate(time.Minute)
 	}
 
-	return statusCode
+	return lmT.After(imsT), nil
+}
+
+// parseHTTPTime parses s as an HTTP-date, in any of the formats permitted by RFC 7231, section 7.1.1.1,
+// so that the same instant is recognized regardless of format.
+func parseHTTPTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC1123, s); err == nil {
+		return t, nil
+	}
+	return http.ParseTime(s)
 }
 
 func headerHandler(f headerFunc, rm ResponseMode, next http.Handler) http.Handler {
+	return headerHandlerOpts(f, nil, rm, next, &options{})
+}
+
+// headerHandlerOpts works like headerHandler, but additionally calls after once the response has been
+// completed, if rm is AfterHeaders, AfterResponse, or HeadersReady. Response writers are configured according to o.
+func headerHandlerOpts(f headerFunc, after afterResponseFunc, rm ResponseMode, next http.Handler,
+	o *options) http.Handler {
+
+	if next == nil {
+		panic(ErrNilHandler)
+	}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !o.pathIncluded(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		switch rm {
 		case BeforeHeaders:
 			f(w, r, 0)
 			next.ServeHTTP(w, r)
+			o.reportBufferStats(nil, r)
 
-		case AfterHeaders, AfterResponse:
+		case AfterHeaders, AfterResponse, HeadersReady:
 			var rw *responseWriter
 			rw = &responseWriter{
-				w:          w,
-				r:          r,
-				bufferBody: rm == AfterResponse,
+				w:                 w,
+				r:                 r,
+				bufferBody:        rm == AfterResponse,
+				eagerHeader:       rm == HeadersReady,
+				maxBufferSize:     o.maxBufferSize,
+				initialBufferCap:  o.initialBufferCapacity,
+				contentTypeFilter: o.contentTypeFilter,
+				notModifiedStatus: o.customNotModifiedStatus(),
 				beforeWriteHeader: func(statusCode int) int {
 					return f(rw, r, statusCode)
 				},
 			}
 			next.ServeHTTP(rw, r)
 			rw.flush()
+			o.reportBufferStats(rw, r)
+
+			if after != nil {
+				after(rw, r)
+			}
 		}
 	})
 }
@@ -226,28 +917,153 @@ func (w *responseWriter) Header() http.Header {
 
 // Header implements http.Handler.
 func (w *responseWriter) Write(b []byte) (int, error) {
-	if w.bufferBody {
+	if w.bufferBody && !w.flushed {
+		if w.excluded() {
+			// send anything buffered so far before the rest of the body, so the body is not reordered
+			w.abandonBuffer()
+			return w.Write(b)
+		}
+
 		if w.bodyBuf == nil {
-			w.bodyBuf = &bytes.Buffer{}
+			w.bodyBuf = w.sharedBuffer()
+			if w.bodyBuf == nil {
+				w.bodyBuf = w.newBuffer()
+			}
+		}
+
+		if w.maxBufferSize <= 0 || w.bodyBuf.Len()+len(b) <= w.maxBufferSize {
+			return w.bodyBuf.Write(b)
 		}
-		return w.bodyBuf.Write(b)
+
+		w.abandonBuffer()
 	}
 
 	w.writeHeader()
+	if w.discardBody {
+		w.discardedBytes += int64(len(b))
+		return len(b), nil
+	}
 	return w.w.Write(b)
 }
 
 // Header implements http.Handler.
 func (w *responseWriter) WriteHeader(statusCode int) {
 	w.statusCode = statusCode
+	if w.eagerHeader {
+		w.writeHeader()
+	}
 }
 
 func (w *responseWriter) flush() {
-	if w.bodyBuf == nil {
+	if w.flushed {
 		return
 	}
+	w.flushed = true
+
+	if w.bodyBuf != nil {
+		w.bufferedSize = w.bodyBuf.Len()
+	}
+
+	// write the header even if the downstream handler has neither called WriteHeader nor written a body,
+	// so that the header function runs and the conditional decision is applied
 	w.writeHeader()
-	_, _ = io.Copy(w.w, w.bodyBuf)
+	if w.bodyBuf == nil {
+		return
+	}
+	if w.discardBody {
+		w.discardedBytes += int64(w.bodyBuf.Len())
+		if w.bufferShared {
+			w.bodyBuf.Reset()
+		}
+		return
+	}
+	if w.bufferShared {
+		// the body is already in the parent's buffer
+		return
+	}
+	_, _ = w.w.Write(w.bodyBuf.Bytes())
+}
+
+// sharedBuffer returns the body buffer of the underlying response writer, if w can write its body into it directly,
+// instead of buffering the body itself and copying it to the underlying response writer later. This is the case
+// if the underlying response writer is a buffering response writer produced by this package that has not buffered
+// anything yet, and neither response writer limits the buffer size or filters content types.
+// If the buffer is shared, w.bufferShared is set to true.
+func (w *responseWriter) sharedBuffer() *bytes.Buffer {
+	p := w.bufferingParent()
+	if p == nil || w.maxBufferSize > 0 {
+		return nil
+	}
+
+	if p.bodyBuf == nil {
+		p.bodyBuf = p.newBuffer()
+	}
+
+	w.bufferShared = true
+	return p.bodyBuf
+}
+
+// newBuffer returns a new body buffer. If the response's Content-Length header is set, and the body will not exceed
+// the maximum buffer size, the buffer is pre-sized to hold the entire body, but not beyond maxContentLengthBufferCap
+// or the initial capacity configured using WithInitialBufferCapacity, whichever is larger, since the header may not
+// be accurate. Otherwise, the buffer is pre-sized to the initial capacity.
+func (w *responseWriter) newBuffer() *bytes.Buffer {
+	c := w.initialBufferCap
+
+	if cl, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil && cl > 0 &&
+		(w.maxBufferSize <= 0 || cl <= w.maxBufferSize) {
+		c = cl
+		if limit := maxInt(maxContentLengthBufferCap, w.initialBufferCap); c > limit {
+			c = limit
+		}
+	}
+
+	if c <= 0 {
+		return &bytes.Buffer{}
+	}
+	return bytes.NewBuffer(make([]byte, 0, c))
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// bufferingParent returns the underlying response writer if it is a buffering response writer produced by this
+// package that can share its buffer, or nil otherwise.
+func (w *responseWriter) bufferingParent() *responseWriter {
+	p, ok := w.w.(*responseWriter)
+	if bw, isBW := w.w.(*BufferingWriter); isBW {
+		p, ok = bw.rw, true
+	}
+
+	switch {
+	case !ok, !p.bufferBody, p.flushed, p.bufferAbandoned, p.maxBufferSize > 0, p.contentTypeFilter != nil:
+		return nil
+	case p.bodyBuf != nil && p.bodyBuf.Len() > 0:
+		return nil
+	default:
+		return p
+	}
+}
+
+// notModified returns whether statusCode is the status code used for responses that have not been modified.
+func (w *responseWriter) notModified(statusCode int) bool {
+	return statusCode == http.StatusNotModified || (w.notModifiedStatus != 0 && statusCode == w.notModifiedStatus)
+}
+
+// excluded returns whether the response has been excluded from processing by the content type filter.
+func (w *responseWriter) excluded() bool {
+	return w.contentTypeFilter != nil && !w.contentTypeFilter(w.Header().Get("Content-Type"))
+}
+
+// abandonBuffer stops buffering the body, and sends the body buffered so far. Once buffering has been abandoned,
+// the body is no longer available through Body.
+func (w *responseWriter) abandonBuffer() {
+	w.bufferAbandoned = true
+	w.flush()
 }
 
 func (w *responseWriter) writeHeader() {
@@ -260,29 +1076,174 @@ func (w *responseWriter) writeHeader() {
 		statusCode = http.StatusOK
 	}
 
-	if w.beforeWriteHeader != nil {
-		defer func() {
-			w.beforeWriteHeader = nil
-		}()
-		statusCode = w.beforeWriteHeader(statusCode)
+	w.contentLength = declaredContentLength(w.Header())
+
+	statusCode, changed := w.applyBeforeWriteHeader(statusCode)
+	w.normalizeDiscarded(statusCode, changed)
+
+	if w.bufferBody && !w.bufferAbandoned && !w.discardBody {
+		w.setBufferedContentLength(statusCode)
 	}
 
 	defer func() {
 		w.headerWritten = true
 	}()
+	w.writtenStatusCode = statusCode
 	w.w.WriteHeader(statusCode)
 }
 
+// declaredContentLength returns the length declared in h's Content-Length header, or -1 if it is not declared.
+func declaredContentLength(h http.Header) int64 {
+	cl, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return cl
+}
+
+// applyBeforeWriteHeader calls this response writer's header function, if any, to produce the status code to send
+// instead of statusCode. It returns the status code to send, and whether it differs from statusCode. If the header
+// function changes the status code such that the body produced by the downstream handler must not be sent,
+// the body is discarded.
+func (w *responseWriter) applyBeforeWriteHeader(statusCode int) (int, bool) {
+	if w.beforeWriteHeader == nil || w.excluded() {
+		return statusCode, false
+	}
+
+	defer func() {
+		w.beforeWriteHeader = nil
+	}()
+
+	newStatusCode := w.beforeWriteHeader(statusCode)
+	if w.failed {
+		newStatusCode = http.StatusInternalServerError
+	}
+
+	changed := newStatusCode != statusCode
+	w.discardBody = changed && (w.notModified(newStatusCode) || newStatusCode == http.StatusInternalServerError)
+
+	return newStatusCode, changed
+}
+
+// normalizeDiscarded removes the headers describing the body produced by the downstream handler if the body
+// will not be sent because the response is sent with statusCode instead. changed reports whether statusCode has
+// been produced by this response writer's header function, rather than by the downstream handler. A custom status
+// code configured using WithNotModifiedStatus is only treated as not modified in that case, since the downstream
+// handler may use the same status code for other purposes.
+func (w *responseWriter) normalizeDiscarded(statusCode int, changed bool) {
+	switch {
+	case statusCode == http.StatusNotModified, changed && w.notModified(statusCode):
+		w.normalizeNotModified()
+	case w.discardBody:
+		// no other body is sent in place of the discarded one, so any length or type declared for it is wrong
+		w.Header().Del("Content-Length")
+		w.Header().Del("Content-Type")
+	}
+}
+
+// normalizeNotModified prepares a response that is sent with the 304 Not Modified status code, regardless of whether
+// the status code has been produced by a handler of this package or by the downstream handler. The body is never
+// sent, and the headers describing it are removed. Validators and other headers are retained.
+func (w *responseWriter) normalizeNotModified() {
+	// the body produced by the downstream handler will not be sent, so any length or type it declared is wrong
+	w.discardBody = true
+	w.Header().Del("Content-Length")
+	w.Header().Del("Content-Type")
+}
+
+// setBufferedContentLength sets the Content-Length header to the length of the buffered body, replacing any
+// Transfer-Encoding header set by the downstream handler, since the body is sent in its entirety. The header is not
+// set if more data may be written after flushing the buffered body.
+func (w *responseWriter) setBufferedContentLength(statusCode int) {
+	if w.streamAfterFlush || w.r.Method == http.MethodHead || !bodyAllowedForStatus(statusCode) {
+		return
+	}
+
+	n := 0
+	if w.bodyBuf != nil {
+		n = w.bodyBuf.Len()
+	}
+
+	w.Header().Del("Transfer-Encoding")
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+}
+
+func bodyAllowedForStatus(statusCode int) bool {
+	switch {
+	case statusCode >= 100 && statusCode <= 199:
+		return false
+	case statusCode == http.StatusNoContent, statusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
 // Body returns w's body content. If w is a buffering response writer produced by this package,
-// Body returns the buffered body contents if any. In all other cases, it returns nil.
+// Body returns the buffered body contents if any. In all other cases, it returns nil. In particular,
+// if buffering has been abandoned because the body exceeded the size configured using WithMaxBufferSize,
+// Body returns nil, since the body is incomplete.
 func Body(w http.ResponseWriter) []byte {
+	if bw, ok := w.(*BufferingWriter); ok {
+		w = bw.rw
+	}
+
 	rw, ok := w.(*responseWriter)
-	if !ok || rw.bodyBuf == nil {
+	if !ok {
+		return nil
+	}
+
+	rw.bodyAccessed = true
+
+	if rw.bodyBuf == nil || rw.bufferAbandoned {
 		return nil
 	}
 	return rw.bodyBuf.Bytes()
 }
 
+// bufferedBody works like Body, but additionally returns whether the body is available at all. Unlike Body,
+// it returns an empty body and ok==true if the body is being buffered but nothing has been written yet, provided
+// that the response could carry a body. For HEAD requests and for status codes that do not allow a body, such as
+// 304 Not Modified, an empty body does not represent the resource's content, and ok==false is returned instead.
+func bufferedBody(w http.ResponseWriter) ([]byte, bool) {
+	b := Body(w)
+	if len(b) > 0 {
+		return b, true
+	}
+
+	if bw, ok := w.(*BufferingWriter); ok {
+		w = bw.rw
+	}
+
+	rw, ok := w.(*responseWriter)
+	if !ok || !rw.bufferBody || rw.bufferAbandoned || !rw.emptyBodyMeaningful() {
+		return nil, false
+	}
+	return []byte{}, true
+}
+
+// emptyBodyMeaningful returns whether an empty body written to w represents an empty representation.
+func (w *responseWriter) emptyBodyMeaningful() bool {
+	statusCode := w.statusCode
+	if statusCode < 100 {
+		statusCode = http.StatusOK
+	}
+	return (w.r == nil || w.r.Method != http.MethodHead) && bodyAllowedForStatus(statusCode)
+}
+
+// BodyAccessed returns whether Body has been called for w. If w is not a response writer produced by this package,
+// BodyAccessed returns false.
+//
+// This can be used to find out whether an ETagFunc or LastModifiedFunc actually uses the response body,
+// and thus whether a cheaper response mode than AfterResponse could be used instead.
+func BodyAccessed(w http.ResponseWriter) bool {
+	if bw, ok := w.(*BufferingWriter); ok {
+		w = bw.rw
+	}
+
+	rw, ok := w.(*responseWriter)
+	return ok && rw.bodyAccessed
+}
+
 func eTagFromString(s string) (ETag, bool) {
 	weak := false
 	if strings.HasPrefix(s, "W/") {
@@ -300,22 +1261,182 @@ func eTagFromString(s string) (ETag, bool) {
 	}, true
 }
 
+// parseETagList parses s, which is a comma-separated list of entity-tags. Entity-tags are scanned as quoted strings,
+// so that commas within opaque-tags do not separate list members.
+func parseETagList(s string, o *options) ([]ETag, bool) {
+	s = unfold(s)
+
+	eTags := make([]ETag, 0, strings.Count(s, ",")+1)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			break
+		}
+
+		member, rest, ok := o.scanETag(s)
+		if !ok {
+			return nil, false
+		}
+
+		e, ok := o.parseETag(member)
+		if !ok {
+			return nil, false
+		}
+		eTags = append(eTags, e)
+
+		s = strings.TrimLeft(rest, " \t")
+		if s != "" && s[0] != ',' {
+			return nil, false
+		}
+	}
+
+	if len(eTags) == 0 {
+		return nil, false
+	}
+
+	return eTags, true
+}
+
+// scanETag scans the entity-tag at the start of s, and returns it without any whitespace between its weak indicator
+// and its opaque-tag, together with the remainder of s.
+func (o *options) scanETag(s string) (string, string, bool) {
+	prefix := ""
+	if strings.HasPrefix(o.normalizeWeakPrefix(s), "W/") {
+		prefix = "W/"
+		s = strings.TrimLeft(s[2:], " \t")
+	}
+
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", false
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && o.escapedQuotes:
+			// skip the escaped character
+			i++
+		case s[i] == '"':
+			return prefix + s[:i+1], s[i+1:], true
+		}
+	}
+
+	return "", "", false
+}
+
+// unfold replaces obsolete line folding (RFC 7230, section 3.2.4) in the header value s with single spaces.
+func unfold(s string) string {
+	if !strings.ContainsAny(s, "\r\n") {
+		return s
+	}
+
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\r' && c != '\n' {
+			b.WriteByte(c)
+			continue
+		}
+
+		for i+1 < len(s) && (s[i+1] == '\r' || s[i+1] == '\n' || s[i+1] == ' ' || s[i+1] == '\t') {
+			i++
+		}
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
 // String implements fmt.Stringer, and returns e's representation usable for the HTTP ETag header,
-// as specified by RFC 7232, section 2.3.
+// as specified by RFC 7232, section 2.3. Any double-quotes surrounding e's Tag are stripped, so that
+// the result always contains exactly one pair of double-quotes.
 func (e ETag) String() string {
-	s := e.Tag
-	if !strings.HasPrefix(s, `"`) && !strings.HasSuffix(s, `"`) {
+	// large enough for hex-encoded SHA-256 hashes, so that only the resulting string is allocated
+	var buf [128]byte
+	return string(e.AppendTo(buf[:0]))
+}
+
+// AppendTo appends e's representation, as returned by String, to b and returns the extended buffer.
+// Unlike String, it does not allocate if b has sufficient capacity, which allows reusing buffers on hot paths.
+func (e ETag) AppendTo(b []byte) []byte {
+	if e.Weak {
+		b = append(b, "W/"...)
+	}
+	b = append(b, '"')
+	b = append(b, trimQuotes(e.Tag)...)
+	return append(b, '"')
+}
+
+// WriteTo implements io.WriterTo, and writes e's representation, as returned by String, to w.
+// It does not allocate if w implements io.StringWriter without allocating, such as *bytes.Buffer,
+// *bufio.Writer, or *strings.Builder.
+func (e ETag) WriteTo(w io.Writer) (int64, error) {
+	parts := [4]string{"", `"`, trimQuotes(e.Tag), `"`}
+	if e.Weak {
+		parts[0] = "W/"
+	}
+
+	total := int64(0)
+	for _, p := range parts {
+		if p == "" {
+			continue
+		}
+
+		n, err := io.WriteString(w, p)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// FormatOptions configures how ETag.Format renders an entity-tag. The zero value renders entity-tags
+// in the same way as ETag.String, as specified by RFC 7232, section 2.3.
+type FormatOptions struct {
+	// WeakPrefix is the prefix used for weak entity-tags. If empty, "W/" is used.
+	WeakPrefix string
+
+	// Unquoted specifies whether the opaque-tag should not be surrounded by double-quotes.
+	Unquoted bool
+}
+
+// Format returns e's representation, rendered according to opts. Any double-quotes surrounding e's Tag are
+// stripped first, in the same way as by String. Options other than the zero value may produce representations
+// that are not compliant with RFC 7232 and that cannot be parsed by the handlers of this package. They are meant
+// for integrations with systems that expect such representations, such as in tests. Use String for HTTP headers.
+func (e ETag) Format(opts FormatOptions) string {
+	s := trimQuotes(e.Tag)
+	if !opts.Unquoted {
 		s = `"` + s + `"`
 	}
+
 	if e.Weak {
-		s = "W/" + s
+		prefix := opts.WeakPrefix
+		if prefix == "" {
+			prefix = "W/"
+		}
+		s = prefix + s
 	}
+
 	return s
 }
 
+func trimQuotes(s string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+}
+
 func (e ETag) equal(e2 ETag, weakComparison bool) bool {
 	if !weakComparison && (e.Weak || e2.Weak) {
 		return false
 	}
 	return e.Tag == e2.Tag
 }
+
+func (e ETag) matchAny(eTags []ETag, weakComparison bool) bool {
+	for _, e2 := range eTags {
+		if e.equal(e2, weakComparison) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,17 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -38,6 +46,125 @@ func TestETag_String(t *testing.T) {
 	}
 }
 
+func TestETag_Format(t *testing.T) {
+	tests := []struct {
+		name     string
+		eTag     ETag
+		opts     FormatOptions
+		wantETag string
+	}{
+		{
+			name:     "zero strong",
+			eTag:     ETag{Tag: "foo"},
+			wantETag: `"foo"`,
+		},
+		{
+			name:     "zero weak",
+			eTag:     ETag{Tag: "foo", Weak: true},
+			wantETag: `W/"foo"`,
+		},
+		{
+			name:     "lowercase prefix",
+			eTag:     ETag{Tag: "foo", Weak: true},
+			opts:     FormatOptions{WeakPrefix: "w/"},
+			wantETag: `w/"foo"`,
+		},
+		{
+			name:     "prefix ignored for strong",
+			eTag:     ETag{Tag: "foo"},
+			opts:     FormatOptions{WeakPrefix: "w/"},
+			wantETag: `"foo"`,
+		},
+		{
+			name:     "unquoted",
+			eTag:     ETag{Tag: `"foo"`, Weak: true},
+			opts:     FormatOptions{Unquoted: true},
+			wantETag: `W/foo`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(test.eTag.Format(test.opts), test.wantETag)
+			is.Equal(test.eTag.Format(FormatOptions{}), test.eTag.String())
+		})
+	}
+
+	is := is.New(t)
+	is.Equal(ETag{Tag: "foo", Weak: true}.String(), `W/"foo"`)
+}
+
+func TestETag_String_Quotes(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+	}{
+		{
+			name: "unquoted",
+			tag:  "foo",
+		},
+		{
+			name: "quoted",
+			tag:  `"foo"`,
+		},
+		{
+			name: "leading quote",
+			tag:  `"foo`,
+		},
+		{
+			name: "trailing quote",
+			tag:  `foo"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(ETag{Tag: test.tag}.String(), `"foo"`)
+			is.Equal(ETag{Tag: test.tag, Weak: true}.String(), `W/"foo"`)
+		})
+	}
+}
+
+func TestETag_AppendTo(t *testing.T) {
+	tests := []struct {
+		name string
+		eTag ETag
+	}{
+		{
+			name: "strong",
+			eTag: ETag{Tag: "foo"},
+		},
+		{
+			name: "weak",
+			eTag: ETag{Tag: "foo", Weak: true},
+		},
+		{
+			name: "quoted",
+			eTag: ETag{Tag: `"foo"`},
+		},
+		{
+			name: "long",
+			eTag: ETag{Tag: strings.Repeat("a", 200), Weak: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			is.Equal(string(test.eTag.AppendTo([]byte("ETag: "))), "ETag: "+test.eTag.String())
+
+			buf := bytes.Buffer{}
+			n, err := test.eTag.WriteTo(&buf)
+			is.NoErr(err)
+			is.Equal(buf.String(), test.eTag.String())
+			is.Equal(n, int64(buf.Len()))
+		})
+	}
+}
+
 func TestETag_Compare(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -146,216 +273,483 @@ func TestETagFromString(t *testing.T) {
 	}
 }
 
-func TestETagHandler(t *testing.T) {
-	is := is.New(t)
-
-	etag := ETag{
-		Tag: "foo",
-	}
-	f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
-		return etag, true
+func TestOptions_ParseETag_EscapedQuotes(t *testing.T) {
+	tests := []struct {
+		name          string
+		escapedQuotes bool
+		s             string
+		wantOK        bool
+		wantTag       string
+	}{
+		{
+			name:          "strict",
+			escapedQuotes: false,
+			s:             `"a\"b"`,
+			wantOK:        false,
+		},
+		{
+			name:          "lenient",
+			escapedQuotes: true,
+			s:             `"a\"b"`,
+			wantOK:        true,
+			wantTag:       `a"b`,
+		},
+		{
+			name:          "lenient unescaped",
+			escapedQuotes: true,
+			s:             `"a"b"`,
+			wantOK:        false,
+		},
 	}
-	h := ETagHandler(f, BeforeHeaders, contentHandler([]byte{}))
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
-	h.ServeHTTP(w, r)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
 
-	is.Equal(w.Result().StatusCode, http.StatusOK)
-	is.Equal(w.Result().Header.Get("ETag"), etag.String())
+			o := newOptions([]Option{WithEscapedQuotes(test.escapedQuotes)})
+			e, ok := o.parseETag(test.s)
+			is.Equal(ok, test.wantOK)
+			if ok {
+				is.Equal(e.Tag, test.wantTag)
+				is.Equal(o.formatETag(e), test.s)
+			}
+		})
+	}
 }
 
-func TestETagHandler_NotOK(t *testing.T) {
+func TestIfNoneMatchIfModifiedSinceHandler_EscapedQuotes(t *testing.T) {
 	is := is.New(t)
 
 	f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
-		return ETag{}, false
+		return ETag{Tag: `a"b`}, true
 	}
-	h := ETagHandler(f, BeforeHeaders, contentHandler([]byte{}))
+	h := ETagHandler(f, BeforeHeaders, contentHandler([]byte{}), WithEscapedQuotes(true))
+	h = IfNoneMatchIfModifiedSinceHandler(false, h, WithEscapedQuotes(true))
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
 	h.ServeHTTP(w, r)
 
 	is.Equal(w.Result().StatusCode, http.StatusOK)
-	is.Equal(w.Result().Header.Get("ETag"), "")
-}
-
-func TestLastModifiedHandler(t *testing.T) {
-	is := is.New(t)
+	eTag := w.Result().Header.Get("ETag")
+	is.Equal(eTag, `"a\"b"`)
 
-	now := time.Now()
-	f := func(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
-		return now, true
-	}
-	h, _ := LastModifiedHandler(f, BeforeHeaders, contentHandler([]byte{}))
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w = httptest.NewRecorder()
+	r.Header.Set("If-None-Match", eTag)
 
 	h.ServeHTTP(w, r)
 
-	is.Equal(w.Result().StatusCode, http.StatusOK)
-	loc, _ := time.LoadLocation("GMT")
-	is.Equal(w.Result().Header.Get("Last-Modified"), now.In(loc).Format(time.RFC1123))
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
 }
 
-func TestLastModifiedHandler_NotOK(t *testing.T) {
+func TestETagHandler(t *testing.T) {
 	is := is.New(t)
 
-	f := func(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
-		return time.Time{}, false
+	etag := ETag{
+		Tag: "foo",
 	}
-	h, _ := LastModifiedHandler(f, BeforeHeaders, contentHandler([]byte{}))
+	f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		return etag, true
+	}
+	h := ETagHandler(f, BeforeHeaders, contentHandler([]byte{}))
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
 	h.ServeHTTP(w, r)
 
 	is.Equal(w.Result().StatusCode, http.StatusOK)
-	is.Equal(w.Result().Header.Get("Last-Modified"), "")
+	is.Equal(w.Result().Header.Get("ETag"), etag.String())
 }
 
-func TestLastModifiedHandlerConstant(t *testing.T) {
+func TestETagHandler_NotOK(t *testing.T) {
 	is := is.New(t)
 
-	now := time.Now()
-	h, _ := LastModifiedHandlerConstant(now, contentHandler([]byte{}))
+	f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		return ETag{}, false
+	}
+	h := ETagHandler(f, BeforeHeaders, contentHandler([]byte{}))
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
 	h.ServeHTTP(w, r)
 
 	is.Equal(w.Result().StatusCode, http.StatusOK)
-	loc, _ := time.LoadLocation("GMT")
-	is.Equal(w.Result().Header.Get("Last-Modified"), now.In(loc).Format(time.RFC1123))
+	is.Equal(w.Result().Header.Get("ETag"), "")
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_NoHeaders(t *testing.T) {
-	is := is.New(t)
+func TestETagHandler_OnlyIfAbsent(t *testing.T) {
+	tests := []struct {
+		name         string
+		onlyIfAbsent bool
+		next         http.Handler
+		wantETag     string
+	}{
+		{
+			name:         "present",
+			onlyIfAbsent: true,
+			next:         contentHandler([]byte("body"), "ETag", `"origin"`),
+			wantETag:     `"origin"`,
+		},
+		{
+			name:         "absent",
+			onlyIfAbsent: true,
+			next:         contentHandler([]byte("body")),
+			wantETag:     `"generated"`,
+		},
+		{
+			name:     "overwrite",
+			next:     contentHandler([]byte("body"), "ETag", `"origin"`),
+			wantETag: `"generated"`,
+		},
+	}
 
-	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", `"foo"`))
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
 
-	h.ServeHTTP(w, r)
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "generated"}, true
+			}
+			h := ETagHandler(f, AfterHeaders, test.next, WithOnlyIfAbsent(test.onlyIfAbsent))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
 
-	is.Equal(w.Result().StatusCode, http.StatusOK)
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+		})
+	}
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch(t *testing.T) {
+func TestETagHandler_Immutable(t *testing.T) {
 	tests := []struct {
-		ifNoneMatchTag string
-		wantStatus     int
+		name          string
+		eTag          ETag
+		wantImmutable bool
 	}{
 		{
-			ifNoneMatchTag: "foo",
-			wantStatus:     http.StatusNotModified,
+			name:          "strong",
+			eTag:          ETag{Tag: "foo"},
+			wantImmutable: true,
 		},
 		{
-			ifNoneMatchTag: "bar",
-			wantStatus:     http.StatusOK,
+			name:          "weak",
+			eTag:          ETag{Tag: "foo", Weak: true},
+			wantImmutable: false,
 		},
 	}
 
 	for _, test := range tests {
-		t.Run(test.ifNoneMatchTag, func(t *testing.T) {
+		t.Run(test.name, func(t *testing.T) {
 			is := is.New(t)
 
-			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", ETag{Tag: "foo"}.String()))
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return test.eTag, true
+			}
+			h := ETagHandler(f, AfterHeaders, contentHandler([]byte{}), WithImmutable())
 			w := httptest.NewRecorder()
 			r := httptest.NewRequest(http.MethodGet, "/", nil)
-			eTag := ETag{
-				Tag: test.ifNoneMatchTag,
-			}
-			r.Header.Set("If-None-Match", eTag.String())
 
 			h.ServeHTTP(w, r)
 
-			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			is.Equal(w.Result().Header.Get("Cache-Control") == "max-age=31536000, immutable", test.wantImmutable)
 		})
 	}
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_NoETag(t *testing.T) {
-	is := is.New(t)
+func TestETagHandler_Immutable_OnlyIfAbsent(t *testing.T) {
+	tests := []struct {
+		name          string
+		eTag          string
+		wantImmutable bool
+	}{
+		{
+			name:          "strong",
+			eTag:          `"foo"`,
+			wantImmutable: true,
+		},
+		{
+			name:          "strong with whitespace",
+			eTag:          ` "foo" `,
+			wantImmutable: true,
+		},
+		{
+			name:          "weak with whitespace",
+			eTag:          ` W/"foo" `,
+			wantImmutable: false,
+		},
+	}
 
-	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}))
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodGet, "/", nil)
-	r.Header.Set("If-None-Match", `"foo"`)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
 
-	h.ServeHTTP(w, r)
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "generated"}, true
+			}
+			h := ETagHandler(f, AfterHeaders, contentHandler([]byte("body"), "ETag", test.eTag),
+				WithOnlyIfAbsent(true), WithImmutable())
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
 
-	is.Equal(w.Result().StatusCode, http.StatusOK)
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			is.Equal(w.Result().Header.Get("Cache-Control") == "max-age=31536000, immutable", test.wantImmutable)
+		})
+	}
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_RequestParseError(t *testing.T) {
+func TestETagFuncFromE(t *testing.T) {
 	is := is.New(t)
 
-	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", ETag{Tag: "foo"}.String()))
+	f := ETagFuncFromE(func(w http.ResponseWriter, r *http.Request) (ETag, bool, error) {
+		return ETag{Tag: "foo"}, true, nil
+	})
+	h := ETagHandler(f, AfterHeaders, contentHandler([]byte{}))
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
-	r.Header.Set("If-None-Match", "bad")
 
 	h.ServeHTTP(w, r)
 
 	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_ResponseParseError(t *testing.T) {
-	is := is.New(t)
+func TestETagFuncFromE_Error(t *testing.T) {
+	errTest := errors.New("test error")
 
-	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", "bad"))
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodGet, "/", nil)
-	r.Header.Set("If-None-Match", ETag{Tag: "foo"}.String())
+	tests := []struct {
+		name        string
+		failOnError bool
+		opts        []Option
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:        "default",
+			failOnError: false,
+			wantStatus:  http.StatusOK,
+			wantBody:    "body",
+		},
+		{
+			name:        "fail",
+			failOnError: true,
+			wantStatus:  http.StatusInternalServerError,
+			wantBody:    "",
+		},
+		{
+			name:        "fail with timeout",
+			failOnError: true,
+			opts:        []Option{WithETagTimeout(time.Minute)},
+			wantStatus:  http.StatusInternalServerError,
+			wantBody:    "",
+		},
+	}
 
-	h.ServeHTTP(w, r)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
 
-	is.Equal(w.Result().StatusCode, http.StatusOK)
+			var reportedErr error
+			errFunc := func(err error, r *http.Request) {
+				reportedErr = err
+			}
+			f := ETagFuncFromE(func(w http.ResponseWriter, r *http.Request) (ETag, bool, error) {
+				return ETag{}, false, errTest
+			}, WithErrorFunc(errFunc), WithFailOnError(test.failOnError))
+			h := ETagHandler(f, AfterHeaders, contentHandler([]byte("body")), test.opts...)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.True(errors.Is(reportedErr, errTest))
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), "")
+			is.Equal(w.Body.String(), test.wantBody)
+		})
+	}
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatchPrecedence(t *testing.T) {
+func TestETagFuncFromE_Error_Server(t *testing.T) {
 	is := is.New(t)
 
-	eTag := ETag{
-		Tag: "foo",
-	}
-	now := time.Now()
-	loc, _ := time.LoadLocation("GMT")
-	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", eTag.String(), "Last-Modified", now.In(loc).Format(time.RFC1123)))
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodGet, "/", nil)
-	r.Header.Set("If-None-Match", eTag.String())
-	r.Header.Set("If-Modified-Since", now.Add(-10*time.Minute).In(loc).Format(time.RFC1123))
+	f := ETagFuncFromE(func(w http.ResponseWriter, r *http.Request) (ETag, bool, error) {
+		return ETag{}, false, errors.New("test error")
+	}, WithFailOnError(true))
+	h := ETagHandler(f, AfterHeaders,
+		contentHandler([]byte("hello"), "Content-Length", "5", "Content-Type", "text/plain"))
 
-	h.ServeHTTP(w, r)
+	res, b := serverResponse(t, h, http.MethodGet)
 
-	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+	is.Equal(res.StatusCode, http.StatusInternalServerError)
+	is.Equal(len(b), 0)
+	is.True(res.ContentLength <= 0)
+	is.Equal(res.Header.Get("Content-Type"), "")
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince(t *testing.T) {
-	lastModifiedTime := time.Now()
+func TestETagFromJSONHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		wantETag string
+		wantErr  bool
+	}{
+		{
+			name:     "strong",
+			value:    `{"etag":"abc","weak":false}`,
+			wantETag: `"abc"`,
+		},
+		{
+			name:     "weak",
+			value:    `{"etag":"abc","weak":true}`,
+			wantETag: `W/"abc"`,
+		},
+		{
+			name: "missing",
+		},
+		{
+			name:    "invalid",
+			value:   `{"etag":`,
+			wantErr: true,
+		},
+		{
+			name:    "no entity-tag",
+			value:   `{"weak":true}`,
+			wantErr: true,
+		},
+	}
 
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var reportedErr error
+			f := ETagFromJSONHeader("X-Resource-Version", WithErrorFunc(func(err error, r *http.Request) {
+				reportedErr = err
+			}))
+			h := ETagHandler(f, AfterHeaders, contentHandler([]byte("body"), "X-Resource-Version", test.value))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+			is.Equal(errors.Is(reportedErr, ErrInvalidJSONHeader), test.wantErr)
+		})
+	}
+}
+
+func TestETagHandler_ContentTypeFilter(t *testing.T) {
 	tests := []struct {
-		name                string
-		ifModifiedSinceTime time.Time
-		wantStatus          int
+		contentType  string
+		wantETag     bool
+		wantBuffered bool
 	}{
 		{
-			name:                "modified",
-			ifModifiedSinceTime: lastModifiedTime.Add(-10 * time.Minute),
-			wantStatus:          http.StatusOK,
+			contentType:  "text/html",
+			wantETag:     true,
+			wantBuffered: true,
 		},
 		{
-			name:                "same date",
-			ifModifiedSinceTime: lastModifiedTime,
-			wantStatus:          http.StatusNotModified,
+			contentType:  "video/mp4",
+			wantETag:     false,
+			wantBuffered: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			is := is.New(t)
+
+			filter := func(contentType string) bool {
+				return !strings.HasPrefix(contentType, "video/")
+			}
+			flushedDuringWrite := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = w.Write([]byte("body"))
+				flushedDuringWrite = w.(*responseWriter).w.(*httptest.ResponseRecorder).Body.Len() > 0
+			})
+			h := ETagHandler(ETagFromBody(), AfterResponse, next, WithContentTypeFilter(filter))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			is.Equal(w.Result().Header.Get("ETag") != "", test.wantETag)
+			is.Equal(flushedDuringWrite, !test.wantBuffered)
+			is.Equal(w.Body.String(), "body")
+		})
+	}
+}
+
+func TestETagHandler_ContentTypeFilter_MidStream(t *testing.T) {
+	is := is.New(t)
+
+	filter := func(contentType string) bool {
+		return !strings.HasPrefix(contentType, "video/")
+	}
+	passedThrough := ""
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("one "))
+		_, _ = w.Write([]byte("two "))
+		w.Header().Set("Content-Type", "video/mp4")
+		_, _ = w.Write([]byte("three "))
+		passedThrough = w.(*responseWriter).w.(*httptest.ResponseRecorder).Body.String()
+		_, _ = w.Write([]byte("four"))
+	})
+	h := ETagHandler(ETagFromBody(), AfterResponse, next, WithContentTypeFilter(filter))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), "")
+	is.Equal(passedThrough, "one two three ") // buffered body sent first, then passed through
+	is.Equal(w.Body.String(), "one two three four")
+}
+
+func TestETagHandler_StrictRangeValidation_PartialContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		eTag       ETag
+		statusCode int
+		strict     bool
+		wantETag   string
+	}{
+		{
+			name:       "weak partial strict",
+			eTag:       ETag{Tag: "foo", Weak: true},
+			statusCode: http.StatusPartialContent,
+			strict:     true,
+			wantETag:   "",
 		},
 		{
-			name:                "not modified",
-			ifModifiedSinceTime: lastModifiedTime.Add(10 * time.Minute),
-			wantStatus:          http.StatusNotModified,
+			name:       "weak partial lenient",
+			eTag:       ETag{Tag: "foo", Weak: true},
+			statusCode: http.StatusPartialContent,
+			strict:     false,
+			wantETag:   `W/"foo"`,
+		},
+		{
+			name:       "strong partial strict",
+			eTag:       ETag{Tag: "foo"},
+			statusCode: http.StatusPartialContent,
+			strict:     true,
+			wantETag:   `"foo"`,
+		},
+		{
+			name:       "weak full strict",
+			eTag:       ETag{Tag: "foo", Weak: true},
+			statusCode: http.StatusOK,
+			strict:     true,
+			wantETag:   `W/"foo"`,
 		},
 	}
 
@@ -363,222 +757,4355 @@ func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			is := is.New(t)
 
-			loc, _ := time.LoadLocation("GMT")
-			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "Last-Modified", lastModifiedTime.In(loc).Format(time.RFC1123)))
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `W/"downstream"`)
+				w.WriteHeader(test.statusCode)
+				_, _ = w.Write([]byte("bo"))
+			})
+			h := ETagHandler(func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return test.eTag, true
+			}, AfterHeaders, next, WithStrictRangeValidation(test.strict))
 			w := httptest.NewRecorder()
 			r := httptest.NewRequest(http.MethodGet, "/", nil)
-			r.Header.Set("If-Modified-Since", test.ifModifiedSinceTime.In(loc).Format(time.RFC1123))
+			r.Header.Set("Range", "bytes=0-1")
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.statusCode)
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+		})
+	}
+}
+
+func TestETagHandler_StrictRangeValidation_PartialContent_DownstreamETag(t *testing.T) {
+	tests := []struct {
+		name     string
+		f        ETagFunc
+		rm       ResponseMode
+		opts     []Option
+		wantETag string
+	}{
+		{
+			name: "no entity-tag",
+			f: func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{}, false
+			},
+			rm:       AfterHeaders,
+			wantETag: "",
+		},
+		{
+			name: "only if absent",
+			f: func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "foo"}, true
+			},
+			rm:       AfterHeaders,
+			opts:     []Option{WithOnlyIfAbsent(true)},
+			wantETag: "",
+		},
+		{
+			name: "before headers",
+			f: func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "foo", Weak: true}, true
+			},
+			rm:       BeforeHeaders,
+			wantETag: "",
+		},
+		{
+			name: "before headers strong",
+			f: func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "foo"}, true
+			},
+			rm:       BeforeHeaders,
+			wantETag: `"foo"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.rm != BeforeHeaders {
+					w.Header().Set("ETag", `W/"downstream"`)
+				}
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write([]byte("bo"))
+			})
+			h := ETagHandler(test.f, test.rm, next, append(test.opts, WithStrictRangeValidation(true))...)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Range", "bytes=0-1")
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusPartialContent)
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+		})
+	}
+}
+
+func TestETagHandler_BufferStats(t *testing.T) {
+	tests := []struct {
+		name         string
+		rm           ResponseMode
+		opts         []Option
+		wantBuffered bool
+		wantSize     int
+	}{
+		{
+			name:         "AfterResponse",
+			rm:           AfterResponse,
+			wantBuffered: true,
+			wantSize:     4,
+		},
+		{
+			name:         "AfterResponse abandoned",
+			rm:           AfterResponse,
+			opts:         []Option{WithMaxBufferSize(2)},
+			wantBuffered: false,
+			wantSize:     0,
+		},
+		{
+			name:         "AfterHeaders",
+			rm:           AfterHeaders,
+			wantBuffered: false,
+			wantSize:     0,
+		},
+		{
+			name:         "BeforeHeaders",
+			rm:           BeforeHeaders,
+			wantBuffered: false,
+			wantSize:     0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			calls := 0
+			var buffered bool
+			var size int
+			opts := append([]Option{WithBufferStats(func(b bool, n int, r *http.Request) {
+				calls++
+				buffered, size = b, n
+			})}, test.opts...)
+			h := ETagHandler(func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "foo"}, true
+			}, test.rm, contentHandler([]byte("body")), opts...)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Body.String(), "body")
+			is.Equal(calls, 1)
+			is.Equal(buffered, test.wantBuffered)
+			is.Equal(size, test.wantSize)
+		})
+	}
+}
+
+func TestConditionalETagHandler(t *testing.T) {
+	body := []byte("body")
+
+	tests := []struct {
+		name        string
+		rm          ResponseMode
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:        "buffered match",
+			rm:          AfterResponse,
+			ifNoneMatch: StableETag(body).String(),
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			name:        "buffered mismatch",
+			rm:          AfterResponse,
+			ifNoneMatch: `"foo"`,
+			wantStatus:  http.StatusOK,
+			wantBody:    "body",
+		},
+		{
+			name:       "buffered no If-None-Match",
+			rm:         AfterResponse,
+			wantStatus: http.StatusOK,
+			wantBody:   "body",
+		},
+		{
+			name:        "BeforeHeaders match",
+			rm:          BeforeHeaders,
+			ifNoneMatch: StableETag(body).String(),
+			wantStatus:  http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			f := ETagFromBody()
+			if test.rm != AfterResponse {
+				f = func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+					return StableETag(body), true
+				}
+			}
+
+			var saved int64
+			h := ConditionalETagHandler(f, test.rm, true, contentHandler(body), WithBytesSaved(func(n int64, r *http.Request) {
+				saved = n
+			}))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
 
 			h.ServeHTTP(w, r)
 
 			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), StableETag(body).String())
+			is.Equal(w.Body.String(), test.wantBody)
+			if test.wantStatus == http.StatusNotModified {
+				is.Equal(w.Result().Header.Get("Content-Length"), "")
+				is.Equal(saved, int64(len(body)))
+			}
 		})
 	}
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince_NoLastModified(t *testing.T) {
+func TestETagHandler_ETagTimeout(t *testing.T) {
 	is := is.New(t)
 
-	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}))
+	done := make(chan struct{})
+	f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		defer close(done)
+		<-r.Context().Done()
+		return ETag{Tag: "foo"}, true
+	}
+
+	var errs []error
+	h := ETagHandler(f, AfterHeaders, contentHandler([]byte("body")), WithETagTimeout(10*time.Millisecond),
+		WithErrorFunc(func(err error, r *http.Request) {
+			errs = append(errs, err)
+		}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+	<-done
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), "")
+	is.Equal(w.Body.String(), "body")
+	is.Equal(len(errs), 1)
+	is.True(errors.Is(errs[0], context.DeadlineExceeded))
+}
+
+func TestETagHandler_ETagTimeout_InTime(t *testing.T) {
+	is := is.New(t)
+
+	h := ETagHandler(func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		_, hasDeadline := r.Context().Deadline()
+		return ETag{Tag: "foo"}, hasDeadline
+	}, AfterHeaders, contentHandler([]byte("body")), WithETagTimeout(time.Minute))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+}
+
+func TestETagHandler_ETagTimeout_Detached(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    time.Duration
+		wait       bool
+		wantETag   string
+		wantDigest bool
+	}{
+		{
+			name:       "in time",
+			timeout:    time.Minute,
+			wantETag:   StableETag([]byte("body")).String(),
+			wantDigest: true,
+		},
+		{
+			name:    "timed out",
+			timeout: 10 * time.Millisecond,
+			wait:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			done := make(chan struct{})
+			body := ETagFromBody(WithContentDigest(true))
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				defer close(done)
+				if test.wait {
+					<-r.Context().Done()
+				}
+				// modifies the header, which must not race with sending the response
+				return body(w, r)
+			}
+
+			h := ETagHandler(f, AfterResponse, contentHandler([]byte("body")), WithETagTimeout(test.timeout))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+			<-done
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			is.Equal(w.Body.String(), "body")
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+			is.Equal(w.Result().Header.Get("Content-Digest") != "", test.wantDigest)
+		})
+	}
+}
+
+func TestETagHandler_ReverseProxy(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"upstream"`)
+		_, _ = w.Write([]byte("body"))
+	})
+	// transform stands in for a reverse proxy that transforms the upstream response's body
+	transform := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uw := httptest.NewRecorder()
+		upstream.ServeHTTP(uw, r)
+		for k, v := range uw.Result().Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(uw.Result().StatusCode)
+		_, _ = w.Write(bytes.ToUpper(uw.Body.Bytes()))
+	})
+	local := StableETag([]byte("BODY")).String()
+
+	tests := []struct {
+		name        string
+		h           http.Handler
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{
+			name:        "layered local",
+			h:           IfNoneMatchIfModifiedSinceHandler(true, ETagHandler(ETagFromBody(), AfterResponse, transform)),
+			ifNoneMatch: local,
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			name:        "layered upstream",
+			h:           IfNoneMatchIfModifiedSinceHandler(true, ETagHandler(ETagFromBody(), AfterResponse, transform)),
+			ifNoneMatch: `"upstream"`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "combined local",
+			h:           ConditionalETagHandler(ETagFromBody(), AfterResponse, true, transform),
+			ifNoneMatch: local,
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			name:        "combined upstream",
+			h:           ConditionalETagHandler(ETagFromBody(), AfterResponse, true, transform),
+			ifNoneMatch: `"upstream"`,
+			wantStatus:  http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+
+			test.h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), local)
+			if test.wantStatus == http.StatusOK {
+				is.Equal(w.Body.String(), "BODY")
+			}
+		})
+	}
+}
+
+func TestLastModifiedHandler(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	f := func(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+		return now, true
+	}
+	h, _ := LastModifiedHandler(f, BeforeHeaders, contentHandler([]byte{}))
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
-	loc, _ := time.LoadLocation("GMT")
-	r.Header.Set("If-Modified-Since", time.Now().In(loc).Format(time.RFC1123))
 
 	h.ServeHTTP(w, r)
 
-	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	loc, _ := time.LoadLocation("GMT")
+	is.Equal(w.Result().Header.Get("Last-Modified"), now.In(loc).Format(time.RFC1123))
+}
+
+func TestLastModifiedHandler_InvalidTime(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+	}{
+		{
+			name: "zero",
+		},
+		{
+			name: "before 1970",
+			t:    time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var errs []error
+			h, err := LastModifiedHandler(func(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+				return test.t, true
+			}, BeforeHeaders, contentHandler([]byte("body")), WithErrorFunc(func(err error, r *http.Request) {
+				errs = append(errs, err)
+			}))
+			is.NoErr(err)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			_, ok := w.Result().Header["Last-Modified"]
+			is.True(!ok)
+			is.Equal(errs, []error{ErrInvalidLastModified})
+		})
+	}
+}
+
+func TestLastModifiedHandler_OnlyIfAbsent(t *testing.T) {
+	tests := []struct {
+		name         string
+		onlyIfAbsent bool
+		wantLM       string
+	}{
+		{
+			name:         "preserve",
+			onlyIfAbsent: true,
+			wantLM:       "Tue, 08 Jun 2021 10:18:15 GMT",
+		},
+		{
+			name:   "overwrite",
+			wantLM: "Wed, 09 Jun 2021 10:18:15 GMT",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h, err := LastModifiedHandler(
+				lastModifiedFunc(time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC), true), AfterHeaders,
+				contentHandler([]byte("body"), "Last-Modified", "Tue, 08 Jun 2021 10:18:15 GMT"),
+				WithOnlyIfAbsent(test.onlyIfAbsent))
+			is.NoErr(err)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().Header.Get("Last-Modified"), test.wantLM)
+		})
+	}
+}
+
+func TestLastModifiedHandler_NotOK(t *testing.T) {
+	is := is.New(t)
+
+	f := func(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+		return time.Time{}, false
+	}
+	h, _ := LastModifiedHandler(f, BeforeHeaders, contentHandler([]byte{}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("Last-Modified"), "")
+}
+
+func TestCustomHeaderNames_Write(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	h, _ := LastModifiedHandler(lastModifiedFunc(now, true), BeforeHeaders, contentHandler([]byte{}),
+		WithLastModifiedHeaderName("X-Origin-Last-Modified"))
+	h = ETagHandler(func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		return ETag{Tag: "foo"}, true
+	}, BeforeHeaders, h, WithETagHeaderName("X-Origin-ETag"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	loc, _ := time.LoadLocation("GMT")
+	is.Equal(w.Result().Header.Get("X-Origin-ETag"), `"foo"`)
+	is.Equal(w.Result().Header.Get("X-Origin-Last-Modified"), now.In(loc).Format(time.RFC1123))
+	is.Equal(w.Result().Header.Get("ETag"), "")
+	is.Equal(w.Result().Header.Get("Last-Modified"), "")
+}
+
+func TestLastModifiedMax(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	f := LastModifiedMax(
+		lastModifiedFunc(now.Add(-10*time.Minute), true),
+		lastModifiedFunc(now, true),
+		lastModifiedFunc(now.Add(-5*time.Minute), true),
+	)
+
+	lm, ok := f(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	is.True(ok)
+	is.True(lm.Equal(now))
+}
+
+func TestLastModifiedMax_NotOK(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	f := LastModifiedMax(
+		lastModifiedFunc(time.Time{}, false),
+		lastModifiedFunc(now, true),
+		lastModifiedFunc(now.Add(time.Minute), false),
+	)
+
+	lm, ok := f(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	is.True(ok)
+	is.True(lm.Equal(now))
+
+	f = LastModifiedMax(lastModifiedFunc(now, false), lastModifiedFunc(now, false))
+
+	_, ok = f(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	is.True(!ok)
+}
+
+func TestLastModifiedHandlerConstant(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	h, _ := LastModifiedHandlerConstant(now, contentHandler([]byte{}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	loc, _ := time.LoadLocation("GMT")
+	is.Equal(w.Result().Header.Get("Last-Modified"), now.In(loc).Format(time.RFC1123))
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_NoHeaders(t *testing.T) {
+	is := is.New(t)
+
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", `"foo"`))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch(t *testing.T) {
+	tests := []struct {
+		ifNoneMatchTag string
+		wantStatus     int
+	}{
+		{
+			ifNoneMatchTag: "foo",
+			wantStatus:     http.StatusNotModified,
+		},
+		{
+			ifNoneMatchTag: "bar",
+			wantStatus:     http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ifNoneMatchTag, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", ETag{Tag: "foo"}.String()))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			eTag := ETag{
+				Tag: test.ifNoneMatchTag,
+			}
+			r.Header.Set("If-None-Match", eTag.String())
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_List(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{
+			ifNoneMatch: `"bar", "foo"`,
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			ifNoneMatch: `"bar" ,  W/ "foo"`,
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			ifNoneMatch: `"bar", "baz"`,
+			wantStatus:  http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ifNoneMatch, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", ETag{Tag: "foo"}.String()))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_MultipleHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    []string
+		wantStatus int
+	}{
+		{
+			name:       "second matches",
+			headers:    []string{`"bar"`, `"foo"`},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "first matches",
+			headers:    []string{`"baz", "foo"`, `"bar"`},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "none matches",
+			headers:    []string{`"bar"`, `"baz"`},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", ETag{Tag: "foo"}.String()))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, inm := range test.headers {
+				r.Header.Add("If-None-Match", inm)
+			}
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_WeakComparisonFunc(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{
+			path:       "/weak",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			path:       "/strong",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			is := is.New(t)
+
+			weakFunc := func(r *http.Request) bool {
+				return r.URL.Path == "/weak"
+			}
+			h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler([]byte{}, "ETag", ETag{Tag: "foo", Weak: true}.String()),
+				WithWeakComparisonFunc(weakFunc))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			r.Header.Set("If-None-Match", ETag{Tag: "foo", Weak: true}.String())
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_FullResponseFuncs(t *testing.T) {
+	tests := []struct {
+		ifNoneMatchTag string
+		wantStatus     int
+		wantCalled     bool
+	}{
+		{
+			ifNoneMatchTag: "foo",
+			wantStatus:     http.StatusNotModified,
+			wantCalled:     false,
+		},
+		{
+			ifNoneMatchTag: "bar",
+			wantStatus:     http.StatusOK,
+			wantCalled:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ifNoneMatchTag, func(t *testing.T) {
+			is := is.New(t)
+
+			called := false
+			f := func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.Header().Set("X-Expensive", "value")
+			}
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", ETag{Tag: "foo"}.String()),
+				WithFullResponseFuncs(f))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", ETag{Tag: test.ifNoneMatchTag}.String())
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(called, test.wantCalled)
+			is.Equal(w.Result().Header.Get("X-Expensive") != "", test.wantCalled)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_BytesSaved(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		ifNoneMatch string
+		wantSaved   int64
+		wantCall    bool
+	}{
+		{
+			name:        "match",
+			method:      http.MethodGet,
+			ifNoneMatch: `"foo"`,
+			wantSaved:   4,
+			wantCall:    true,
+		},
+		{
+			name:        "match HEAD",
+			method:      http.MethodHead,
+			ifNoneMatch: `"foo"`,
+			wantSaved:   4,
+			wantCall:    true,
+		},
+		{
+			name:        "no match",
+			method:      http.MethodGet,
+			ifNoneMatch: `"bar"`,
+			wantCall:    false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			called := false
+			var saved int64
+			f := func(n int64, r *http.Request) {
+				called = true
+				saved = n
+			}
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"foo"`)
+				w.Header().Set("Content-Length", "4")
+				w.WriteHeader(http.StatusOK)
+				if r.Method != http.MethodHead {
+					_, _ = w.Write([]byte("bo"))
+					_, _ = w.Write([]byte("dy"))
+				}
+			})
+			h := IfNoneMatchIfModifiedSinceHandler(true, next, WithBytesSaved(f))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, "/", nil)
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(called, test.wantCall)
+			is.Equal(saved, test.wantSaved)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_Negotiation(t *testing.T) {
+	tests := []struct {
+		accept      string
+		ifNoneMatch string
+		wantETag    string
+		wantStatus  int
+	}{
+		{
+			accept:      "application/json",
+			ifNoneMatch: `"json"`,
+			wantETag:    `"json"`,
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			accept:      "text/html",
+			ifNoneMatch: `"json"`,
+			wantETag:    `"html"`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			accept:      "text/html",
+			ifNoneMatch: `"json", "html"`,
+			wantETag:    `"html"`,
+			wantStatus:  http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept+" "+test.ifNoneMatch, func(t *testing.T) {
+			is := is.New(t)
+
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				if r.Header.Get("Accept") == "application/json" {
+					return ETag{Tag: "json"}, true
+				}
+				return ETag{Tag: "html"}, true
+			}
+			h := ETagHandler(f, BeforeHeaders, contentHandler([]byte{}, "Vary", "Accept"))
+			h = IfNoneMatchIfModifiedSinceHandler(false, h)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", test.accept)
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+			is.Equal(w.Result().Header.Get("Vary"), "Accept")
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_EligibleStatusCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantStatus int
+	}{
+		{
+			name:       "default",
+			wantStatus: http.StatusPermanentRedirect,
+		},
+		{
+			name:       "eligible",
+			opts:       []Option{WithEligibleStatusCodes(http.StatusMovedPermanently, http.StatusPermanentRedirect)},
+			wantStatus: http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", ETag{Tag: "foo"}.String())
+				http.Redirect(w, r, "/bar", http.StatusPermanentRedirect)
+			})
+			h := IfNoneMatchIfModifiedSinceHandler(false, next, test.opts...)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", ETag{Tag: "foo"}.String())
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), ETag{Tag: "foo"}.String())
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IneligibleStatusCode(t *testing.T) {
+	is := is.New(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", ETag{Tag: "foo"}.String())
+		http.Error(w, "Not Found", http.StatusNotFound)
+	})
+	h := IfNoneMatchIfModifiedSinceHandler(false, next)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", ETag{Tag: "foo"}.String())
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotFound)
+}
+
+func TestETagHandler_HTTPError(t *testing.T) {
+	tests := []struct {
+		name       string
+		eligible   bool
+		wantStatus int
+	}{
+		{
+			name:       "eligible",
+			eligible:   true,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "ineligible",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Not Found", http.StatusNotFound)
+			})
+
+			opts := []Option{}
+			if test.eligible {
+				opts = append(opts, WithEligibleStatusCodes(http.StatusNotFound))
+			}
+
+			h := IfNoneMatchIfModifiedSinceHandler(false, ETagHandler(ETagFromBody(), AfterResponse, next), opts...)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotFound)
+			is.Equal(w.Body.String(), "Not Found\n")
+			is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("Not Found\n")).String())
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", StableETag([]byte("Not Found\n")).String())
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("Not Found\n")).String())
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_StrictRangeValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		eTag       ETag
+		strict     bool
+		wantStatus int
+	}{
+		{
+			name:       "weak strict",
+			eTag:       ETag{Tag: "foo", Weak: true},
+			strict:     true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "weak lenient",
+			eTag:       ETag{Tag: "foo", Weak: true},
+			strict:     false,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "strong strict",
+			eTag:       ETag{Tag: "foo"},
+			strict:     true,
+			wantStatus: http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			body := []byte("body")
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler(body, "ETag", test.eTag.String()),
+				WithStrictRangeValidation(test.strict))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Range", "bytes=0-1")
+			r.Header.Set("If-None-Match", test.eTag.String())
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			if test.wantStatus == http.StatusOK {
+				b, _ := io.ReadAll(w.Result().Body)
+				is.Equal(b, body)
+			}
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_ContentLength(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch       string
+		wantStatus        int
+		wantContentLength string
+	}{
+		{
+			ifNoneMatch:       `"foo"`,
+			wantStatus:        http.StatusNotModified,
+			wantContentLength: "",
+		},
+		{
+			ifNoneMatch:       `"bar"`,
+			wantStatus:        http.StatusOK,
+			wantContentLength: "100",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ifNoneMatch, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler(make([]byte, 100),
+				"ETag", `"foo"`, "Content-Length", "100"))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("Content-Length"), test.wantContentLength)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_WeakMatchWarning(t *testing.T) {
+	warning := `110 - "Response is Stale"`
+
+	tests := []struct {
+		name        string
+		eTag        ETag
+		ifNoneMatch string
+		wantWarning string
+	}{
+		{
+			name:        "strong match",
+			eTag:        ETag{Tag: "foo"},
+			ifNoneMatch: `"foo"`,
+			wantWarning: "",
+		},
+		{
+			name:        "weak response",
+			eTag:        ETag{Tag: "foo", Weak: true},
+			ifNoneMatch: `"foo"`,
+			wantWarning: warning,
+		},
+		{
+			name:        "weak request",
+			eTag:        ETag{Tag: "foo"},
+			ifNoneMatch: `W/"foo"`,
+			wantWarning: warning,
+		},
+		{
+			name:        "strong and weak request",
+			eTag:        ETag{Tag: "foo"},
+			ifNoneMatch: `W/"foo", "foo"`,
+			wantWarning: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", test.eTag.String()),
+				WithWeakMatchWarning(warning))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotModified)
+			is.Equal(w.Result().Header.Get("Warning"), test.wantWarning)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_CustomHeaderNames(t *testing.T) {
+	loc, _ := time.LoadLocation("GMT")
+	now := time.Now().In(loc).Format(time.RFC1123)
+
+	tests := []struct {
+		name       string
+		headerKV   []string
+		reqHeader  string
+		reqValue   string
+		wantStatus int
+	}{
+		{
+			name:       "ETag",
+			headerKV:   []string{"X-Origin-ETag", `"foo"`},
+			reqHeader:  "If-None-Match",
+			reqValue:   `"foo"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "ETag standard name",
+			headerKV:   []string{"ETag", `"foo"`},
+			reqHeader:  "If-None-Match",
+			reqValue:   `"foo"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Last-Modified",
+			headerKV:   []string{"X-Origin-Last-Modified", now},
+			reqHeader:  "If-Modified-Since",
+			reqValue:   now,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "Last-Modified standard name",
+			headerKV:   []string{"Last-Modified", now},
+			reqHeader:  "If-Modified-Since",
+			reqValue:   now,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler([]byte{}, test.headerKV...),
+				WithETagHeaderName("X-Origin-ETag"), WithLastModifiedHeaderName("X-Origin-Last-Modified"))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(test.reqHeader, test.reqValue)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_ContentTypeFilter(t *testing.T) {
+	tests := []struct {
+		contentType string
+		wantStatus  int
+	}{
+		{
+			contentType: "text/html",
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			contentType: "video/mp4",
+			wantStatus:  http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			is := is.New(t)
+
+			filter := func(contentType string) bool {
+				return !strings.HasPrefix(contentType, "video/")
+			}
+			h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler([]byte("body"), "ETag", `"foo"`, "Content-Type", test.contentType),
+				WithContentTypeFilter(filter))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", `"foo"`)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_ServeReason(t *testing.T) {
+	tests := []struct {
+		name       string
+		next       http.Handler
+		reqHeaders []string
+		wantReason ServeReason
+	}{
+		{
+			name:       "not modified",
+			next:       contentHandler([]byte("body"), "ETag", `"foo"`),
+			reqHeaders: []string{"If-None-Match", `"foo"`},
+			wantReason: ServeReasonNotModified,
+		},
+		{
+			name:       "no conditional headers",
+			next:       contentHandler([]byte("body"), "ETag", `"foo"`),
+			wantReason: ServeReasonNoConditionalHeaders,
+		},
+		{
+			name:       "no validator",
+			next:       contentHandler([]byte("body")),
+			reqHeaders: []string{"If-None-Match", `"foo"`},
+			wantReason: ServeReasonNoValidator,
+		},
+		{
+			name:       "entity-tag mismatch",
+			next:       contentHandler([]byte("body"), "ETag", `"bar"`),
+			reqHeaders: []string{"If-None-Match", `"foo"`},
+			wantReason: ServeReasonETagMismatch,
+		},
+		{
+			name:       "modified",
+			next:       contentHandler([]byte("body"), "Last-Modified", "Wed, 09 Jun 2021 10:18:15 GMT"),
+			reqHeaders: []string{"If-Modified-Since", "Tue, 08 Jun 2021 10:18:15 GMT"},
+			wantReason: ServeReasonModified,
+		},
+		{
+			name:       "parse error",
+			next:       contentHandler([]byte("body"), "ETag", `"foo"`),
+			reqHeaders: []string{"If-None-Match", `foo`},
+			wantReason: ServeReasonParseError,
+		},
+		{
+			name:       "date parse error",
+			next:       contentHandler([]byte("body"), "Last-Modified", "Wed, 09 Jun 2021 10:18:15 GMT"),
+			reqHeaders: []string{"If-Modified-Since", "yesterday"},
+			wantReason: ServeReasonParseError,
+		},
+		{
+			name:       "ineligible status",
+			next:       noContentHandler(),
+			reqHeaders: []string{"If-None-Match", `"foo"`},
+			wantReason: ServeReasonIneligibleStatus,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var reasons []ServeReason
+			h := IfNoneMatchIfModifiedSinceHandler(false, test.next, WithServeReason(func(reason ServeReason, r *http.Request) {
+				reasons = append(reasons, reason)
+			}))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for i := 0; i < len(test.reqHeaders); i += 2 {
+				r.Header.Set(test.reqHeaders[i], test.reqHeaders[i+1])
+			}
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(reasons, []ServeReason{test.wantReason})
+		})
+	}
+}
+
+func TestServeReason_String(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(ServeReasonETagMismatch.String(), "entity-tag mismatch")
+	is.Equal(ServeReason(100).String(), "ServeReason(100)")
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_DuplicateIfModifiedSince(t *testing.T) {
+	is := is.New(t)
+
+	var errs []error
+	h := IfNoneMatchIfModifiedSinceHandler(false,
+		contentHandler([]byte("body"), "Last-Modified", "Wed, 09 Jun 2021 10:18:15 GMT"),
+		WithErrorFunc(func(err error, r *http.Request) {
+			errs = append(errs, err)
+		}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Add("If-Modified-Since", "Wed, 09 Jun 2021 10:18:15 GMT")
+	r.Header.Add("If-Modified-Since", "Thu, 10 Jun 2021 10:18:15 GMT")
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Body.String(), "body")
+	is.Equal(errs, []error{ErrDuplicateIfModifiedSince})
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_RegisterValidator(t *testing.T) {
+	tests := []struct {
+		name       string
+		reqHeaders []string
+		wantStatus int
+	}{
+		{
+			name:       "match",
+			reqHeaders: []string{"If-Schedule-Tag-Match", "12"},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "mismatch",
+			reqHeaders: []string{"If-Schedule-Tag-Match", "11"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "If-None-Match first",
+			reqHeaders: []string{"If-None-Match", `"bar"`, "If-Schedule-Tag-Match", "12"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "If-Modified-Since ignored",
+			reqHeaders: []string{"If-Schedule-Tag-Match", "11", "If-Modified-Since", "Wed, 09 Jun 2021 10:18:15 GMT"},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(false,
+				contentHandler([]byte("body"), "ETag", `"foo"`, "Schedule-Tag", "12", "Last-Modified", "Wed, 09 Jun 2021 10:18:15 GMT"),
+				RegisterValidator("If-Schedule-Tag-Match", "Schedule-Tag", func(a string, b string) bool {
+					return a == b
+				}))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for i := 0; i < len(test.reqHeaders); i += 2 {
+				r.Header.Set(test.reqHeaders[i], test.reqHeaders[i+1])
+			}
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_Debug304Body(t *testing.T) {
+	tests := []struct {
+		debug    bool
+		wantBody string
+	}{
+		{
+			debug:    true,
+			wantBody: "304 Not Modified: If-None-Match: \"foo\"; ETag: \"foo\"\n",
+		},
+		{
+			debug: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.FormatBool(test.debug), func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler([]byte("body"), "ETag", `"foo"`),
+				WithDebug304Body(test.debug))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", `"foo"`)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotModified)
+			is.Equal(w.Body.String(), test.wantBody)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_MaxConditionHeaderBytes(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        int
+		wantStatus int
+		wantErrs   []error
+	}{
+		{
+			name:       "oversized",
+			max:        32,
+			wantStatus: http.StatusOK,
+			wantErrs:   []error{ErrConditionHeaderTooLarge},
+		},
+		{
+			name:       "unlimited",
+			max:        -1,
+			wantStatus: http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var errs []error
+			h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler([]byte("body"), "ETag", `"foo"`),
+				WithMaxConditionHeaderBytes(test.max),
+				WithErrorFunc(func(err error, r *http.Request) {
+					errs = append(errs, err)
+				}))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", strings.Repeat(`"bar", `, 10)+`"foo"`)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(errs, test.wantErrs)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_MismatchCarriesValidators(t *testing.T) {
+	modTime := time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC)
+
+	for _, rm := range []ResponseMode{BeforeHeaders, AfterHeaders, AfterResponse} {
+		rm := rm
+
+		t.Run(strconv.Itoa(int(rm)), func(t *testing.T) {
+			is := is.New(t)
+
+			var h http.Handler = contentHandler([]byte("body"))
+			h = ETagHandler(func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "current"}, true
+			}, rm, h)
+			h, err := LastModifiedHandler(func(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+				return modTime, true
+			}, rm, h)
+			is.NoErr(err)
+			h = IfNoneMatchIfModifiedSinceHandler(false, h)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", `"outdated"`)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			is.Equal(w.Result().Header.Get("ETag"), `"current"`)
+			is.Equal(w.Result().Header.Get("Last-Modified"), "Wed, 09 Jun 2021 10:18:15 GMT")
+			is.Equal(w.Body.String(), "body")
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_NotModifiedStatus(t *testing.T) {
+	is := is.New(t)
+
+	const statusFresh = 399
+
+	h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler([]byte("body"), "ETag", `"foo"`, "Content-Length", "4"),
+		WithNotModifiedStatus(statusFresh))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"foo"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, statusFresh)
+	is.Equal(w.Result().Header.Get("Content-Length"), "")
+	is.Equal(w.Body.Len(), 0)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_NotModifiedStatus_Downstream(t *testing.T) {
+	is := is.New(t)
+
+	const statusFresh = 399
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"foo"`)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "4")
+		w.WriteHeader(statusFresh)
+		_, _ = w.Write([]byte("body"))
+	})
+	h := IfNoneMatchIfModifiedSinceHandler(false, next, WithNotModifiedStatus(statusFresh))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"bar"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, statusFresh)
+	is.Equal(w.Result().Header.Get("Content-Type"), "text/plain")
+	is.Equal(w.Result().Header.Get("Content-Length"), "4")
+	is.Equal(w.Body.String(), "body")
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_NotModifiedStatus_Invalid(t *testing.T) {
+	is := is.New(t)
+
+	var errs []error
+	h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler([]byte("body"), "ETag", `"foo"`),
+		WithNotModifiedStatus(http.StatusOK),
+		WithErrorFunc(func(err error, r *http.Request) {
+			errs = append(errs, err)
+		}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"foo"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+	is.Equal(w.Body.Len(), 0)
+	is.Equal(len(errs), 1)
+	is.True(errors.Is(errs[0], ErrInvalidNotModifiedStatus))
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IneligibleMethod_Server(t *testing.T) {
+	is := is.New(t)
+
+	var reason ServeReason
+	h := IfNoneMatchIfModifiedSinceHandler(true,
+		contentHandler([]byte("hello"), "ETag", `"foo"`, "Content-Length", "5", "Content-Type", "text/plain"),
+		WithServeReason(func(r ServeReason, _ *http.Request) {
+			reason = r
+		}))
+
+	res, b := serverResponse(t, h, http.MethodPost, "If-None-Match", `"foo"`)
+
+	is.Equal(res.StatusCode, http.StatusOK)
+	is.Equal(string(b), "hello")
+	is.Equal(res.Header.Get("Content-Type"), "text/plain")
+	is.Equal(reason, ServeReasonIneligibleMethod)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_EligibleMethods(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		opts       []Option
+		statusCode int
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{
+			name:       "GET",
+			method:     http.MethodGet,
+			header:     "If-None-Match",
+			value:      `"foo"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "REPORT",
+			method:     "REPORT",
+			header:     "If-None-Match",
+			value:      `"foo"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "REPORT eligible",
+			method:     "REPORT",
+			opts:       []Option{WithEligibleMethods("REPORT")},
+			header:     "If-None-Match",
+			value:      `"foo"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "POST",
+			method:     http.MethodPost,
+			header:     "If-None-Match",
+			value:      `"foo"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "POST If-Modified-Since",
+			method:     http.MethodPost,
+			header:     "If-Modified-Since",
+			value:      "Wed, 09 Jun 2021 10:18:15 GMT",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "REPORT eligible If-Modified-Since",
+			method:     "REPORT",
+			opts:       []Option{WithEligibleMethods("REPORT")},
+			header:     "If-Modified-Since",
+			value:      "Wed, 09 Jun 2021 10:18:15 GMT",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "OPTIONS eligible",
+			method:     http.MethodOptions,
+			opts:       []Option{WithEligibleMethods(http.MethodOptions)},
+			header:     "If-None-Match",
+			value:      `"foo"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "PROPFIND multi-status",
+			method:     "PROPFIND",
+			opts:       []Option{WithEligibleMethods("PROPFIND")},
+			statusCode: http.StatusMultiStatus,
+			header:     "If-None-Match",
+			value:      `"foo"`,
+			wantStatus: http.StatusMultiStatus,
+		},
+		{
+			name:       "PROPFIND multi-status eligible",
+			method:     "PROPFIND",
+			opts:       []Option{WithEligibleMethods("PROPFIND"), WithEligibleStatusCodes(http.StatusMultiStatus)},
+			statusCode: http.StatusMultiStatus,
+			header:     "If-None-Match",
+			value:      `"foo"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "PROPFIND multi-status eligible status only",
+			method:     "PROPFIND",
+			opts:       []Option{WithEligibleStatusCodes(http.StatusMultiStatus)},
+			statusCode: http.StatusMultiStatus,
+			header:     "If-None-Match",
+			value:      `"foo"`,
+			wantStatus: http.StatusMultiStatus,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"foo"`)
+				w.Header().Set("Last-Modified", "Wed, 09 Jun 2021 10:18:15 GMT")
+				if test.statusCode != 0 {
+					w.WriteHeader(test.statusCode)
+				}
+				_, _ = w.Write([]byte("body"))
+			})
+			h := IfNoneMatchIfModifiedSinceHandler(false, next, test.opts...)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, "/", nil)
+			r.Header.Set(test.header, test.value)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_Identical(t *testing.T) {
+	tests := []struct {
+		name       string
+		eTag       string
+		weak       bool
+		wantStatus int
+	}{
+		{
+			name:       "strong",
+			eTag:       `"foo"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "weak, weak comparison",
+			eTag:       `W/"foo"`,
+			weak:       true,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "weak, strong comparison",
+			eTag:       `W/"foo"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "malformed",
+			eTag:       `foo`,
+			weak:       true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "inner quotes",
+			eTag:       `"foo"bar"`,
+			weak:       true,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(test.weak, contentHandler([]byte("body"), "ETag", test.eTag))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.eTag)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_Folded(t *testing.T) {
+	is := is.New(t)
+
+	h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler([]byte("body"), "ETag", `"foo"`))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header["If-None-Match"] = []string{"\"bar\",\r\n \"foo\""}
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_NoETag(t *testing.T) {
+	is := is.New(t)
+
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"foo"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_RequestParseError(t *testing.T) {
+	is := is.New(t)
+
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", ETag{Tag: "foo"}.String()))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", "bad")
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatch_ResponseParseError(t *testing.T) {
+	is := is.New(t)
+
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", "bad"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", ETag{Tag: "foo"}.String())
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatchPrecedence(t *testing.T) {
+	is := is.New(t)
+
+	eTag := ETag{
+		Tag: "foo",
+	}
+	now := time.Now()
+	loc, _ := time.LoadLocation("GMT")
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", eTag.String(), "Last-Modified", now.In(loc).Format(time.RFC1123)))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", eTag.String())
+	r.Header.Set("If-Modified-Since", now.Add(-10*time.Minute).In(loc).Format(time.RFC1123))
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfNoneMatchPrecedence_Mismatch(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	loc, _ := time.LoadLocation("GMT")
+	var reasons []ServeReason
+	h := IfNoneMatchIfModifiedSinceHandler(true,
+		contentHandler([]byte("body"), "ETag", `"foo"`, "Last-Modified", now.Add(-10*time.Minute).In(loc).Format(time.RFC1123)),
+		WithServeReason(func(reason ServeReason, r *http.Request) {
+			reasons = append(reasons, reason)
+		}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"bar"`)
+	r.Header.Set("If-Modified-Since", now.In(loc).Format(time.RFC1123))
+
+	h.ServeHTTP(w, r)
+
+	// RFC 7232, section 3.3: If-Modified-Since must be ignored if If-None-Match is present
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Body.String(), "body")
+	is.Equal(reasons, []ServeReason{ServeReasonETagMismatch})
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince(t *testing.T) {
+	lastModifiedTime := time.Now()
+
+	tests := []struct {
+		name                string
+		ifModifiedSinceTime time.Time
+		wantStatus          int
+	}{
+		{
+			name:                "modified",
+			ifModifiedSinceTime: lastModifiedTime.Add(-10 * time.Minute),
+			wantStatus:          http.StatusOK,
+		},
+		{
+			name:                "same date",
+			ifModifiedSinceTime: lastModifiedTime,
+			wantStatus:          http.StatusNotModified,
+		},
+		{
+			name:                "not modified",
+			ifModifiedSinceTime: lastModifiedTime.Add(10 * time.Minute),
+			wantStatus:          http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			loc, _ := time.LoadLocation("GMT")
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "Last-Modified", lastModifiedTime.In(loc).Format(time.RFC1123)))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-Modified-Since", test.ifModifiedSinceTime.In(loc).Format(time.RFC1123))
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince_SubSecond(t *testing.T) {
+	lastModifiedTime := time.Date(2021, 1, 2, 3, 4, 5, 123456789, time.UTC)
+
+	tests := []struct {
+		name                string
+		ifModifiedSinceTime time.Time
+	}{
+		{
+			name:                "echoed",
+			ifModifiedSinceTime: lastModifiedTime,
+		},
+		{
+			name:                "truncated",
+			ifModifiedSinceTime: lastModifiedTime.Truncate(time.Second),
+		},
+		{
+			name:                "same second",
+			ifModifiedSinceTime: lastModifiedTime.Truncate(time.Second).Add(999 * time.Millisecond),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h, _ := LastModifiedHandler(lastModifiedFunc(lastModifiedTime, true), BeforeHeaders, contentHandler([]byte{}))
+			h = IfNoneMatchIfModifiedSinceHandler(true, h)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-Modified-Since", test.ifModifiedSinceTime.Format(http.TimeFormat))
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotModified)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince_Formats(t *testing.T) {
+	lastModified := "Wed, 09 Jun 2021 10:18:15 GMT"
+
+	tests := []struct {
+		name            string
+		ifModifiedSince string
+		wantStatus      int
+	}{
+		{
+			name:            "RFC850 same instant",
+			ifModifiedSince: "Wednesday, 09-Jun-21 10:18:15 GMT",
+			wantStatus:      http.StatusNotModified,
+		},
+		{
+			name:            "ANSI C same instant",
+			ifModifiedSince: "Wed Jun  9 10:18:15 2021",
+			wantStatus:      http.StatusNotModified,
+		},
+		{
+			name:            "RFC850 later",
+			ifModifiedSince: "Wednesday, 09-Jun-21 10:18:16 GMT",
+			wantStatus:      http.StatusNotModified,
+		},
+		{
+			name:            "RFC850 earlier",
+			ifModifiedSince: "Wednesday, 09-Jun-21 10:18:14 GMT",
+			wantStatus:      http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "Last-Modified", lastModified))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-Modified-Since", test.ifModifiedSince)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince_MinuteGranularity(t *testing.T) {
+	tests := []struct {
+		name            string
+		minute          bool
+		ifModifiedSince string
+		wantStatus      int
+	}{
+		{
+			name:            "seconds differ",
+			minute:          true,
+			ifModifiedSince: "Wed, 09 Jun 2021 10:18:00 GMT",
+			wantStatus:      http.StatusNotModified,
+		},
+		{
+			name:            "seconds differ default",
+			minute:          false,
+			ifModifiedSince: "Wed, 09 Jun 2021 10:18:00 GMT",
+			wantStatus:      http.StatusOK,
+		},
+		{
+			name:            "minutes differ",
+			minute:          true,
+			ifModifiedSince: "Wed, 09 Jun 2021 10:17:59 GMT",
+			wantStatus:      http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "Last-Modified", "Wed, 09 Jun 2021 10:18:15 GMT"),
+				WithMinuteGranularity(test.minute))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-Modified-Since", test.ifModifiedSince)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_BothValidators(t *testing.T) {
+	lastModified := time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		ifNoneMatch     string
+		ifModifiedSince time.Time
+		wantStatus      int
+	}{
+		{
+			name:            "ETag matches, modified since",
+			ifNoneMatch:     `"foo"`,
+			ifModifiedSince: lastModified.Add(-time.Hour),
+			wantStatus:      http.StatusNotModified,
+		},
+		{
+			name:            "ETag does not match, not modified since",
+			ifNoneMatch:     `"bar"`,
+			ifModifiedSince: lastModified.Add(time.Hour),
+			wantStatus:      http.StatusOK,
+		},
+		{
+			name:            "both match",
+			ifNoneMatch:     `"foo"`,
+			ifModifiedSince: lastModified,
+			wantStatus:      http.StatusNotModified,
+		},
+		{
+			name:            "neither matches",
+			ifNoneMatch:     `"bar"`,
+			ifModifiedSince: lastModified.Add(-time.Hour),
+			wantStatus:      http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := ETagHandler(func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "foo"}, true
+			}, BeforeHeaders, contentHandler([]byte("body")))
+			h, _ = LastModifiedHandler(lastModifiedFunc(lastModified, true), BeforeHeaders, h)
+			h = IfNoneMatchIfModifiedSinceHandler(true, h)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+			r.Header.Set("If-Modified-Since", test.ifModifiedSince.Format(http.TimeFormat))
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+			is.Equal(w.Result().Header.Get("Last-Modified"), lastModified.Format(http.TimeFormat))
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSinceStore(t *testing.T) {
+	lastModified := "Wed, 09 Jun 2021 10:18:15 GMT"
+	touched := "Wed, 09 Jun 2021 10:20:00 GMT"
+
+	tests := []struct {
+		name             string
+		store            bool
+		nextETag         string
+		nextLastModified string
+		wantStatus       int
+	}{
+		{
+			name:             "changed within same second",
+			store:            true,
+			nextETag:         `"v2"`,
+			nextLastModified: lastModified,
+			wantStatus:       http.StatusOK,
+		},
+		{
+			name:             "changed within same second without store",
+			store:            false,
+			nextETag:         `"v2"`,
+			nextLastModified: lastModified,
+			wantStatus:       http.StatusNotModified,
+		},
+		{
+			name:             "unchanged",
+			store:            true,
+			nextETag:         `"v1"`,
+			nextLastModified: lastModified,
+			wantStatus:       http.StatusNotModified,
+		},
+		{
+			name:             "touched without change",
+			store:            true,
+			nextETag:         `"v1"`,
+			nextLastModified: touched,
+			wantStatus:       http.StatusNotModified,
+		},
+		{
+			name:             "touched without change without store",
+			store:            false,
+			nextETag:         `"v1"`,
+			nextLastModified: touched,
+			wantStatus:       http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var opts []Option
+			if test.store {
+				opts = append(opts, WithIfModifiedSinceStore(NewETagCache(10)))
+			}
+
+			eTag, lm := `"v1"`, lastModified
+			h := IfNoneMatchIfModifiedSinceHandler(true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", eTag)
+				w.Header().Set("Last-Modified", lm)
+				_, _ = w.Write([]byte("body"))
+			}), opts...)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+
+			eTag, lm = test.nextETag, test.nextLastModified
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, "/foo", nil)
+			r.Header.Set("If-Modified-Since", lastModified)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSinceStore_Concurrent(t *testing.T) {
+	is := is.New(t)
+
+	lastModified := "Wed, 09 Jun 2021 10:18:15 GMT"
+
+	h := IfNoneMatchIfModifiedSinceHandler(true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", r.Header.Get("X-ETag"))
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = w.Write([]byte("body"))
+	}), WithIfModifiedSinceStore(NewETagCache(10)))
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			r.Header.Set("X-ETag", `"v`+strconv.Itoa(i%2)+`"`)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, eTag := range []string{`"v0"`, `"v1"`} {
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		r.Header.Set("X-ETag", eTag)
+		r.Header.Set("If-Modified-Since", lastModified)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		is.Equal(w.Code, http.StatusOK)
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSinceStore_Sequence(t *testing.T) {
+	lastModified := "Wed, 09 Jun 2021 10:18:15 GMT"
+	touched := "Wed, 09 Jun 2021 10:20:00 GMT"
+
+	type step struct {
+		eTag         string
+		lastModified string
+		ims          string
+		wantStatus   int
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "changed twice within same second",
+			steps: []step{
+				{eTag: `"v1"`, lastModified: lastModified, wantStatus: http.StatusOK},
+				{eTag: `"v2"`, lastModified: lastModified, wantStatus: http.StatusOK},
+				{eTag: `"v2"`, lastModified: lastModified, ims: lastModified, wantStatus: http.StatusOK},
+				{eTag: `"v2"`, lastModified: lastModified, ims: lastModified, wantStatus: http.StatusOK},
+			},
+		},
+		{
+			name: "changed after revalidation within same second",
+			steps: []step{
+				{eTag: `"v1"`, lastModified: lastModified, wantStatus: http.StatusOK},
+				{eTag: `"v1"`, lastModified: lastModified, ims: lastModified, wantStatus: http.StatusNotModified},
+				{eTag: `"v2"`, lastModified: lastModified, ims: lastModified, wantStatus: http.StatusOK},
+				{eTag: `"v2"`, lastModified: lastModified, ims: lastModified, wantStatus: http.StatusOK},
+			},
+		},
+		{
+			name: "touched repeatedly",
+			steps: []step{
+				{eTag: `"v1"`, lastModified: lastModified, wantStatus: http.StatusOK},
+				{eTag: `"v1"`, lastModified: touched, wantStatus: http.StatusOK},
+				{eTag: `"v1"`, lastModified: touched, ims: lastModified, wantStatus: http.StatusNotModified},
+				{eTag: `"v1"`, lastModified: touched, ims: lastModified, wantStatus: http.StatusNotModified},
+				{eTag: `"v1"`, lastModified: touched, ims: touched, wantStatus: http.StatusNotModified},
+			},
+		},
+		{
+			name: "touched and changed",
+			steps: []step{
+				{eTag: `"v1"`, lastModified: lastModified, wantStatus: http.StatusOK},
+				{eTag: `"v2"`, lastModified: touched, wantStatus: http.StatusOK},
+				{eTag: `"v2"`, lastModified: touched, ims: lastModified, wantStatus: http.StatusOK},
+				{eTag: `"v2"`, lastModified: touched, ims: touched, wantStatus: http.StatusNotModified},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var current step
+			h := IfNoneMatchIfModifiedSinceHandler(true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", current.eTag)
+				w.Header().Set("Last-Modified", current.lastModified)
+				_, _ = w.Write([]byte("body"))
+			}), WithIfModifiedSinceStore(NewETagCache(10)))
+
+			for _, current = range test.steps {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+				if current.ims != "" {
+					r.Header.Set("If-Modified-Since", current.ims)
+				}
+
+				h.ServeHTTP(w, r)
+
+				is.Equal(w.Result().StatusCode, current.wantStatus)
+			}
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_PathPrefixes(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			path:       "/static/app.js",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			path:       "/api/items",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			path:       "/admin/",
+			wantStatus: http.StatusOK,
+			wantBody:   "body",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			is := is.New(t)
+
+			called := false
+			h := IfNoneMatchIfModifiedSinceHandler(true, ETagHandler(func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				called = true
+				return ETag{Tag: "foo"}, true
+			}, AfterResponse, contentHandler([]byte("body"), "ETag", `"foo"`), WithPathPrefixes("/static/", "/api/")),
+				WithPathPrefixes("/static/", "/api/"))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			r.Header.Set("If-None-Match", `"foo"`)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Body.String(), test.wantBody)
+			is.Equal(called, test.wantStatus == http.StatusNotModified)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_SilentHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		rm          ResponseMode
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{
+			name:        "match",
+			rm:          AfterHeaders,
+			ifNoneMatch: `"foo"`,
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			name:        "mismatch",
+			rm:          AfterHeaders,
+			ifNoneMatch: `"bar"`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "buffered match",
+			rm:          AfterResponse,
+			ifNoneMatch: `"foo"`,
+			wantStatus:  http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"foo"`)
+			})
+			h := IfNoneMatchIfModifiedSinceHandler(true, headerHandler(
+				func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+					return statusCode
+				}, test.rm, next))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+			is.Equal(w.Body.Len(), 0)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_ResponseETagWhitespace(t *testing.T) {
+	tests := []struct {
+		name       string
+		eTag       string
+		inm        string
+		wantStatus int
+	}{
+		{
+			name:       "surrounding spaces",
+			eTag:       ` "foo" `,
+			inm:        `"foo"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "surrounding tabs",
+			eTag:       "\t\"foo\"\t",
+			inm:        `"foo"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "weak",
+			eTag:       ` W/"foo"`,
+			inm:        `W/"foo"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "mismatch",
+			eTag:       ` "foo" `,
+			inm:        `"bar"`,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte("body"), "ETag", test.eTag))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.inm)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_ResponseETagWhitespace_StrictRangeValidation(t *testing.T) {
+	is := is.New(t)
+
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte("body"), "ETag", ` "foo" `),
+		WithStrictRangeValidation(true))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Range", "bytes=0-1")
+	r.Header.Set("If-None-Match", `"foo"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_ResponseETagWhitespace_IfModifiedSinceStore(t *testing.T) {
+	is := is.New(t)
+
+	lastModified := "Wed, 09 Jun 2021 10:18:15 GMT"
+
+	eTag := ` "v1" `
+	h := IfNoneMatchIfModifiedSinceHandler(true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", eTag)
+		w.Header().Set("Last-Modified", lastModified)
+		_, _ = w.Write([]byte("body"))
+	}), WithIfModifiedSinceStore(NewETagCache(10)))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+
+	eTag = ` "v2" `
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/foo", nil)
+	r.Header.Set("If-Modified-Since", lastModified)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_CommaInETag(t *testing.T) {
+	tests := []struct {
+		inm        string
+		wantStatus int
+	}{
+		{
+			inm:        `"a,b"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			inm:        `"x", "a,b"`,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			inm:        `"a", "b"`,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.inm, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte("body"), "ETag", `"a,b"`))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.inm)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_ETagSuffixes(t *testing.T) {
+	tests := []struct {
+		name       string
+		eTag       string
+		inm        string
+		suffixes   []string
+		weak       bool
+		wantStatus int
+	}{
+		{
+			name:       "gzip suffix",
+			eTag:       `"abc"`,
+			inm:        `W/"abc-gzip"`,
+			suffixes:   []string{"-gzip"},
+			weak:       true,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "response suffixed",
+			eTag:       `W/"abc-gzip"`,
+			inm:        `"abc"`,
+			suffixes:   []string{"-gzip"},
+			weak:       true,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "list",
+			eTag:       `"abc"`,
+			inm:        `"foo", W/"abc-br"`,
+			suffixes:   []string{"-gzip", "-br"},
+			weak:       true,
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "no suffixes",
+			eTag:       `"abc"`,
+			inm:        `W/"abc-gzip"`,
+			weak:       true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "strong comparison",
+			eTag:       `"abc"`,
+			inm:        `W/"abc-gzip"`,
+			suffixes:   []string{"-gzip"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "different tag",
+			eTag:       `"abc"`,
+			inm:        `W/"abd-gzip"`,
+			suffixes:   []string{"-gzip"},
+			weak:       true,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(test.weak, contentHandler([]byte("body"), "ETag", test.eTag),
+				WithETagSuffixes(test.suffixes...))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", test.inm)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_ContentLocation(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantValue string
+	}{
+		{
+			name:      "default",
+			wantValue: "/foo.de.html",
+		},
+		{
+			name:      "enabled",
+			opts:      []Option{WithNotModifiedContentLocation(true)},
+			wantValue: "/foo.de.html",
+		},
+		{
+			name: "disabled",
+			opts: []Option{WithNotModifiedContentLocation(false)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchIfModifiedSinceHandler(true,
+				contentHandler([]byte("body"), "ETag", `"foo"`, "Content-Location", "/foo.de.html"), test.opts...)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			r.Header.Set("If-None-Match", `"foo"`)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotModified)
+			is.Equal(w.Result().Header.Get("Content-Location"), test.wantValue)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_DownstreamNotModified(t *testing.T) {
+	tests := []struct {
+		name string
+		rm   ResponseMode
+		body bool
+	}{
+		{
+			name: "after headers",
+			rm:   AfterHeaders,
+		},
+		{
+			name: "after headers with body",
+			rm:   AfterHeaders,
+			body: true,
+		},
+		{
+			name: "after response",
+			rm:   AfterResponse,
+		},
+		{
+			name: "after response with body",
+			rm:   AfterResponse,
+			body: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"foo"`)
+				w.Header().Set("Last-Modified", "Wed, 09 Jun 2021 10:18:15 GMT")
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Length", "4")
+				w.WriteHeader(http.StatusNotModified)
+				if test.body {
+					_, _ = w.Write([]byte("body"))
+				}
+			})
+			h := headerHandler(
+				func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+					return statusCode
+				},
+				test.rm, IfNoneMatchIfModifiedSinceHandler(true, next))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", `"bar"`)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotModified)
+			is.Equal(w.Body.Len(), 0)
+			is.Equal(w.Result().Header.Get("Content-Type"), "")
+			is.Equal(w.Result().Header.Get("Content-Length"), "")
+			is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+			is.Equal(w.Result().Header.Get("Last-Modified"), "Wed, 09 Jun 2021 10:18:15 GMT")
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_StrictStatusCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		rangeReq   bool
+		strict     bool
+		wantErrs   []error
+	}{
+		{
+			name:       "unexpected partial content",
+			statusCode: http.StatusPartialContent,
+			strict:     true,
+			wantErrs:   []error{ErrUnexpectedPartialContent},
+		},
+		{
+			name:       "range request",
+			statusCode: http.StatusPartialContent,
+			rangeReq:   true,
+			strict:     true,
+		},
+		{
+			name:       "full content",
+			statusCode: http.StatusOK,
+			strict:     true,
+		},
+		{
+			name:       "not strict",
+			statusCode: http.StatusPartialContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var errs []error
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"foo"`)
+				w.WriteHeader(test.statusCode)
+				_, _ = w.Write([]byte("bo"))
+			})
+			h := IfNoneMatchIfModifiedSinceHandler(true, next, WithStrictStatusCheck(test.strict),
+				WithErrorFunc(func(err error, r *http.Request) {
+					errs = append(errs, err)
+				}))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.rangeReq {
+				r.Header.Set("Range", "bytes=0-1")
+			}
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.statusCode)
+			is.Equal(w.Body.String(), "bo")
+			is.Equal(errs, test.wantErrs)
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince_NoLastModified(t *testing.T) {
+	is := is.New(t)
+
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	loc, _ := time.LoadLocation("GMT")
+	r.Header.Set("If-Modified-Since", time.Now().In(loc).Format(time.RFC1123))
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince_RequestParseError(t *testing.T) {
+	is := is.New(t)
+
+	loc, _ := time.LoadLocation("GMT")
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "Last-Modified", time.Now().In(loc).Format(time.RFC1123)))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-Modified-Since", "bad")
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince_ResponseParseError(t *testing.T) {
+	is := is.New(t)
+
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "Last-Modified", "bad"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	loc, _ := time.LoadLocation("GMT")
+	r.Header.Set("If-Modified-Since", time.Now().In(loc).Format(time.RFC1123))
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfModifiedSinceHandler(t *testing.T) {
+	lastModifiedTime := time.Now()
+
+	tests := []struct {
+		name                string
+		ifModifiedSinceTime time.Time
+		wantStatus          int
+	}{
+		{
+			name:                "modified",
+			ifModifiedSinceTime: lastModifiedTime.Add(-10 * time.Minute),
+			wantStatus:          http.StatusOK,
+		},
+		{
+			name:                "not modified",
+			ifModifiedSinceTime: lastModifiedTime.Add(10 * time.Minute),
+			wantStatus:          http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			loc, _ := time.LoadLocation("GMT")
+			h := IfModifiedSinceHandler(contentHandler([]byte{}, "Last-Modified", lastModifiedTime.In(loc).Format(time.RFC1123)))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-Modified-Since", test.ifModifiedSinceTime.In(loc).Format(time.RFC1123))
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfModifiedSinceHandler_Range(t *testing.T) {
+	tests := []struct {
+		name       string
+		ims        string
+		strict     bool
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "not modified",
+			ims:        "Wed, 09 Jun 2021 10:18:15 GMT",
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "modified",
+			ims:        "Wed, 09 Jun 2021 10:18:14 GMT",
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "bo",
+		},
+		{
+			name:       "not modified strict",
+			ims:        "Wed, 09 Jun 2021 10:18:15 GMT",
+			strict:     true,
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "bo",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			modTime := time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC)
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+				if r.Header.Get("Range") == "" {
+					_, _ = w.Write([]byte("body"))
+					return
+				}
+				w.Header().Set("Content-Range", "bytes 0-1/4")
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write([]byte("bo"))
+			})
+
+			handlers := []http.Handler{
+				IfModifiedSinceHandler(next, WithStrictRangeValidation(test.strict)),
+				IfNoneMatchIfModifiedSinceHandler(true, next, WithStrictRangeValidation(test.strict)),
+			}
+
+			for _, h := range handlers {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.Header.Set("If-Modified-Since", test.ims)
+				r.Header.Set("Range", "bytes=0-1")
+
+				h.ServeHTTP(w, r)
+
+				is.Equal(w.Result().StatusCode, test.wantStatus)
+				is.Equal(w.Body.String(), test.wantBody)
+			}
+		})
+	}
+}
+
+func TestIfModifiedSinceHandler_IgnoreIfNoneMatch(t *testing.T) {
+	is := is.New(t)
+
+	loc, _ := time.LoadLocation("GMT")
+	now := time.Now()
+	h := IfModifiedSinceHandler(contentHandler([]byte{}, "ETag", `"foo"`, "Last-Modified", now.In(loc).Format(time.RFC1123)))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"bar"`)
+	r.Header.Set("If-Modified-Since", now.In(loc).Format(time.RFC1123))
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"foo"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfNoneMatchHandler(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{
+			ifNoneMatch: `"foo"`,
+			wantStatus:  http.StatusNotModified,
+		},
+		{
+			ifNoneMatch: `"bar"`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			ifNoneMatch: "",
+			wantStatus:  http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ifNoneMatch, func(t *testing.T) {
+			is := is.New(t)
+
+			h := IfNoneMatchHandler(false, contentHandler([]byte{}, "ETag", `"foo"`))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfNoneMatchHandler_IgnoreIfModifiedSince(t *testing.T) {
+	is := is.New(t)
+
+	loc, _ := time.LoadLocation("GMT")
+	now := time.Now()
+	h := IfNoneMatchHandler(false, contentHandler([]byte{}, "ETag", `"foo"`, "Last-Modified", now.In(loc).Format(time.RFC1123)))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-Modified-Since", now.Add(10*time.Minute).In(loc).Format(time.RFC1123))
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+}
+
+func TestIfMatchHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		ifMatch    string
+		wantStatus int
+	}{
+		{
+			name:       "match",
+			ifMatch:    `"foo"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "no match",
+			ifMatch:    `"bar"`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "weak",
+			ifMatch:    `W/"foo"`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "any",
+			ifMatch:    "*",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "parse error",
+			ifMatch:    "bad",
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "list, second matches",
+			ifMatch:    `"bar", "foo"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "list, no match",
+			ifMatch:    `"bar", "baz"`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "list, weak match only",
+			ifMatch:    `"bar", W/"foo"`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "list, parse error",
+			ifMatch:    `"foo", bad`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "foo"}, true
+			}
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+				_, _ = w.Write([]byte("body"))
+			})
+			h := IfMatchHandler(f, next)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/", nil)
+			r.Header.Set("If-Match", test.ifMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(nextCalled, test.wantStatus == http.StatusOK)
+			if test.wantStatus == http.StatusPreconditionFailed {
+				is.Equal(w.Body.Len(), 0)
+			}
+		})
+	}
+}
+
+func TestTryMatchIfMatch_ResponseETagWhitespace(t *testing.T) {
+	tests := []struct {
+		name       string
+		ifMatch    string
+		wantStatus int
+	}{
+		{
+			name:       "match",
+			ifMatch:    `"foo"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "no match",
+			ifMatch:    `"bar"`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "any",
+			ifMatch:    "*",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			w := headerWriter{}
+			w.Header().Set("ETag", ` "foo" `)
+			r := httptest.NewRequest(http.MethodPut, "/", nil)
+			r.Header.Set("If-Match", test.ifMatch)
+
+			is.Equal(tryMatchIfMatch(w, r, &options{}, http.StatusOK), test.wantStatus)
+		})
+	}
+}
+
+func TestIfMatchHandler_CommaInETag(t *testing.T) {
+	tests := []struct {
+		ifMatch    string
+		wantStatus int
+	}{
+		{
+			ifMatch:    `"a,b"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			ifMatch:    `"x", "a,b"`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			ifMatch:    `"a", "b"`,
+			wantStatus: http.StatusPreconditionFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ifMatch, func(t *testing.T) {
+			is := is.New(t)
+
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "a,b"}, true
+			}
+			h := IfMatchHandler(f, contentHandler([]byte("body")))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/", nil)
+			r.Header.Set("If-Match", test.ifMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+		})
+	}
+}
+
+func TestIfMatchHandler_NoETag(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+	}{
+		{ifMatch: `"foo"`},
+		{ifMatch: "*"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ifMatch, func(t *testing.T) {
+			is := is.New(t)
+
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{}, false
+			}
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			h := IfMatchHandler(f, next)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/", nil)
+			r.Header.Set("If-Match", test.ifMatch)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusPreconditionFailed)
+			is.True(!nextCalled)
+		})
+	}
+}
+
+func TestIfMatchHandler_Server(t *testing.T) {
+	is := is.New(t)
+
+	f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		return ETag{Tag: "foo"}, true
+	}
+	nextCalled := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+		contentHandler([]byte("hello"), "ETag", `"foo"`, "Content-Length", "5", "Content-Type", "text/plain").
+			ServeHTTP(w, r)
+	})
+	h := IfMatchHandler(f, next)
+
+	res, b := serverResponse(t, h, http.MethodPut, "If-Match", `"bar"`)
+
+	is.Equal(res.StatusCode, http.StatusPreconditionFailed)
+	is.Equal(len(b), 0)
+	is.True(!nextCalled)
+}
+
+func TestIfMatchHandler_Update(t *testing.T) {
+	is := is.New(t)
+
+	version := 1
+	f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		return ETag{Tag: strconv.Itoa(version)}, true
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version++
+		w.Header().Set("ETag", ETag{Tag: strconv.Itoa(version)}.String())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := IfMatchHandler(f, next)
+
+	update := func(ifMatch string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		r.Header.Set("If-Match", ifMatch)
+		h.ServeHTTP(w, r)
+		return w.Result().StatusCode
+	}
+
+	is.Equal(update(`"1"`), http.StatusNoContent)
+	is.Equal(version, 2)
+
+	is.Equal(update(`"1"`), http.StatusPreconditionFailed)
+	is.Equal(version, 2)
+
+	is.Equal(update(`"2"`), http.StatusNoContent)
+	is.Equal(version, 3)
+}
+
+func TestIfMatchHandler_NoIfMatch(t *testing.T) {
+	is := is.New(t)
+
+	fCalled := false
+	f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		fCalled = true
+		return ETag{Tag: "foo"}, true
+	}
+	h := IfMatchHandler(f, contentHandler([]byte{}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.True(!fCalled)
+}
+
+func TestIfMatchHandler_PathPrefixes(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		wantStatus     int
+		wantNextCalled bool
+	}{
+		{
+			name:       "included",
+			path:       "/api/foo",
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "excluded",
+			path:           "/static/foo",
+			wantStatus:     http.StatusOK,
+			wantNextCalled: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "foo"}, true
+			}
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			h := IfMatchHandler(f, next, WithPathPrefixes("/api/"))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, test.path, nil)
+			r.Header.Set("If-Match", `"bar"`)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(nextCalled, test.wantNextCalled)
+		})
+	}
+}
+
+func TestIfMatchHandler_Trailer(t *testing.T) {
+	tests := []struct {
+		name            string
+		requestTrailers bool
+		wantStatus      int
+	}{
+		{
+			name:            "enabled",
+			requestTrailers: true,
+			wantStatus:      http.StatusPreconditionFailed,
+		},
+		{
+			name:            "disabled",
+			requestTrailers: false,
+			wantStatus:      http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "foo"}, true
+			}
+			var body []byte
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+			})
+			h := IfMatchHandler(f, next, WithRequestTrailers(test.requestTrailers))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("body"))
+			r.Trailer = http.Header{}
+			r.Trailer.Set("If-Match", ETag{Tag: "bar"}.String())
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			if test.wantStatus == http.StatusOK {
+				is.Equal(string(body), "body")
+			}
+		})
+	}
+}
+
+func TestParseETagList_LenientWeakPrefix(t *testing.T) {
+	tests := []struct {
+		s         string
+		lenient   bool
+		wantOK    bool
+		wantETags []ETag
+	}{
+		{
+			s:         `w/"a", W/"b"`,
+			lenient:   true,
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a", Weak: true}, {Tag: "b", Weak: true}},
+		},
+		{
+			s:         `W/"a", w/"b", "c", w/ "d"`,
+			lenient:   true,
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a", Weak: true}, {Tag: "b", Weak: true}, {Tag: "c"}, {Tag: "d", Weak: true}},
+		},
+		{
+			s:       `w/"a", W/"b"`,
+			lenient: false,
+			wantOK:  false,
+		},
+		{
+			s:       `W/"a", w/"b"`,
+			lenient: false,
+			wantOK:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			is := is.New(t)
+			eTags, ok := parseETagList(test.s, newOptions([]Option{WithLenientWeakPrefix(test.lenient)}))
+			is.Equal(ok, test.wantOK)
+			if ok {
+				is.Equal(eTags, test.wantETags)
+			}
+		})
+	}
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_LenientWeakPrefix(t *testing.T) {
+	is := is.New(t)
+
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", `w/"foo"`), WithLenientWeakPrefix(true))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `w/"bar", w/"foo"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+}
+
+func TestIfMatchHandler_RequirePrecondition(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		headerName   string
+		wantStatus   int
+		wantNextCall bool
+	}{
+		{
+			name:         "PUT without If-Match",
+			method:       http.MethodPut,
+			wantStatus:   http.StatusPreconditionRequired,
+			wantNextCall: false,
+		},
+		{
+			name:         "PUT with If-Match",
+			method:       http.MethodPut,
+			headerName:   "If-Match",
+			wantStatus:   http.StatusOK,
+			wantNextCall: true,
+		},
+		{
+			name:         "PUT with If-Unmodified-Since",
+			method:       http.MethodPut,
+			headerName:   "If-Unmodified-Since",
+			wantStatus:   http.StatusOK,
+			wantNextCall: true,
+		},
+		{
+			name:         "GET without If-Match",
+			method:       http.MethodGet,
+			wantStatus:   http.StatusOK,
+			wantNextCall: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			nextCalled := false
+			f := func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+				return ETag{Tag: "foo"}, true
+			}
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			h := IfMatchHandler(f, next, WithRequirePrecondition(http.MethodPut, http.MethodDelete))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, "/", nil)
+			switch test.headerName {
+			case "If-Match":
+				r.Header.Set("If-Match", ETag{Tag: "foo"}.String())
+			case "If-Unmodified-Since":
+				r.Header.Set("If-Unmodified-Since", "Sat, 01 Jan 2000 00:00:00 GMT")
+			}
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(nextCalled, test.wantNextCall)
+		})
+	}
+}
+
+func TestParseETagList(t *testing.T) {
+	tests := []struct {
+		s         string
+		wantOK    bool
+		wantETags []ETag
+	}{
+		{
+			s:         `"a"`,
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a"}},
+		},
+		{
+			s:         `"a", W/"b"`,
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a"}, {Tag: "b", Weak: true}},
+		},
+		{
+			s:         "\"a\",\r\n \"b\"",
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a"}, {Tag: "b"}},
+		},
+		{
+			s:         "\"a\"\r\n\t, W/\r\n \"b\"",
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a"}, {Tag: "b", Weak: true}},
+		},
+		{
+			s:         `"a" , W/ "b"`,
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a"}, {Tag: "b", Weak: true}},
+		},
+		{
+			s:         "\t\"a\"\t,\tW/\"b\" ,",
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a"}, {Tag: "b", Weak: true}},
+		},
+		{
+			s:         `  "a",,  W/"b"  `,
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a"}, {Tag: "b", Weak: true}},
+		},
+		{
+			s:         `"a,b"`,
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a,b"}},
+		},
+		{
+			s:         `"x", "a,b"`,
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "x"}, {Tag: "a,b"}},
+		},
+		{
+			s:         `W/ "a, b" ,"c,"`,
+			wantOK:    true,
+			wantETags: []ETag{{Tag: "a, b", Weak: true}, {Tag: "c,"}},
+		},
+		{
+			s:      `"a, "b"`,
+			wantOK: false,
+		},
+		{
+			s:      `"a,b`,
+			wantOK: false,
+		},
+		{
+			s:      `"a", bad`,
+			wantOK: false,
+		},
+		{
+			s:      `"a" "b"`,
+			wantOK: false,
+		},
+		{
+			s:      `W /"a"`,
+			wantOK: false,
+		},
+		{
+			s:      " , ",
+			wantOK: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			is := is.New(t)
+			eTags, ok := parseETagList(test.s, &options{})
+			is.Equal(ok, test.wantOK)
+			if ok {
+				is.Equal(eTags, test.wantETags)
+			}
+		})
+	}
+}
+
+func TestNilHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func()
+	}{
+		{
+			name: "ETagHandler",
+			f: func() {
+				_ = ETagHandler(ETagFromBody(), AfterResponse, nil)
+			},
+		},
+		{
+			name: "IfNoneMatchIfModifiedSinceHandler",
+			f: func() {
+				_ = IfNoneMatchIfModifiedSinceHandler(true, nil)
+			},
+		},
+		{
+			name: "IfMatchHandler",
+			f: func() {
+				_ = IfMatchHandler(nil, nil)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			defer func() {
+				err, _ := recover().(error)
+				is.True(errors.Is(err, ErrNilHandler))
+				is.True(strings.Contains(err.Error(), "next handler must not be nil"))
+			}()
+
+			test.f()
+		})
+	}
+}
+
+func TestNilHandler_Error(t *testing.T) {
+	is := is.New(t)
+
+	_, err := LastModifiedHandler(func(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+		return time.Time{}, false
+	}, BeforeHeaders, nil)
+	is.True(errors.Is(err, ErrNilHandler))
+
+	_, err = LastModifiedHandlerConstant(time.Now(), nil)
+	is.True(errors.Is(err, ErrNilHandler))
+}
+
+func TestHeaderHandler_BeforeHeaders(t *testing.T) {
+	is := is.New(t)
+
+	fCalled := false
+	f := func(_ http.ResponseWriter, r *http.Request, statusCode int) int {
+		fCalled = true
+		return statusCode
+	}
+	body := []byte("body")
+	h := headerHandler(f, BeforeHeaders, contentHandler(body, "X-Test", "testValue"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.True(fCalled)
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, body)
+}
+
+func TestHeaderHandler_AfterHeaders(t *testing.T) {
+	is := is.New(t)
+
+	fCalled := false
+	var headerValue string
+	var bodyContent []byte
+	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+		fCalled = true
+		headerValue = w.Header().Get("X-Test")
+		bodyContent = Body(w)
+		return statusCode
+	}
+	body := []byte("body")
+	h := headerHandler(f, AfterHeaders, contentHandler(body, "X-Test", "testValue"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.True(fCalled)
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(headerValue, "testValue")
+	is.True(bodyContent == nil)
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, body)
+}
+
+func TestHeaderHandler_HeadersReady(t *testing.T) {
+	is := is.New(t)
+
+	fCalled := false
+	fCalledBeforeBody := false
+	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+		fCalled = true
+		w.Header().Set("X-Test", "testValue")
+		return statusCode
+	}
+	h := headerHandler(f, HeadersReady, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fCalledBeforeBody = fCalled
+
+		for i := 0; i < 3; i++ {
+			_, _ = w.Write([]byte("chunk"))
+		}
+	}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.True(fCalledBeforeBody)
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("X-Test"), "testValue")
+	is.Equal(w.Body.String(), "chunkchunkchunk")
+}
+
+func TestHeaderHandler_AfterHeaders_NoContent(t *testing.T) {
+	is := is.New(t)
+
+	fCalled := false
+	var bodyContent []byte
+	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+		fCalled = true
+		bodyContent = Body(w)
+		return statusCode
+	}
+	h := headerHandler(f, AfterHeaders, noContentHandler())
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.True(fCalled)
+	is.Equal(w.Result().StatusCode, http.StatusNoContent)
+	is.True(bodyContent == nil)
+}
+
+func TestHeaderHandler_AfterHeaders_ChangeStatus(t *testing.T) {
+	is := is.New(t)
+
+	fCalled := false
+	var headerValue string
+	var bodyContent []byte
+	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+		fCalled = true
+		headerValue = w.Header().Get("X-Test")
+		bodyContent = Body(w)
+		return http.StatusCreated
+	}
+	body := []byte("body")
+	h := headerHandler(f, AfterHeaders, contentHandler(body, "X-Test", "testValue"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.True(fCalled)
+	is.Equal(w.Result().StatusCode, http.StatusCreated)
+	is.Equal(headerValue, "testValue")
+	is.True(bodyContent == nil)
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, body)
+}
+
+func TestHeaderHandler_AfterResponse(t *testing.T) {
+	is := is.New(t)
+
+	fCalled := false
+	var headerValue string
+	var bodyContent []byte
+	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+		fCalled = true
+		headerValue = w.Header().Get("X-Test")
+		bodyContent = Body(w)
+		return statusCode
+	}
+	body := []byte("body")
+	h := headerHandler(f, AfterResponse, contentHandler(body, "X-Test", "testValue"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.True(fCalled)
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(headerValue, "testValue")
+	is.Equal(bodyContent, body)
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, body)
+}
+
+func TestBodyAccessed(t *testing.T) {
+	tests := []struct {
+		name         string
+		accessBody   bool
+		wantAccessed bool
+	}{
+		{
+			name:         "accessed",
+			accessBody:   true,
+			wantAccessed: true,
+		},
+		{
+			name: "not accessed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var accessedBefore, accessedAfter bool
+			f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+				accessedBefore = BodyAccessed(w)
+				if test.accessBody {
+					_ = Body(w)
+				}
+				accessedAfter = BodyAccessed(w)
+				return statusCode
+			}
+			h := headerHandler(f, AfterResponse, contentHandler([]byte("body")))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.True(!accessedBefore)
+			is.Equal(accessedAfter, test.wantAccessed)
+		})
+	}
+}
+
+func TestHeaderHandler_AfterResponse_Nested(t *testing.T) {
+	tests := []struct {
+		name        string
+		innerStatus int
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:        "pass",
+			innerStatus: http.StatusOK,
+			wantStatus:  http.StatusOK,
+			wantBody:    "body",
+		},
+		{
+			name:        "discard",
+			innerStatus: http.StatusNotModified,
+			wantStatus:  http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var innerRW, outerRW *responseWriter
+			var innerBody, outerBody []byte
+			inner := headerHandler(func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+				innerRW = w.(*responseWriter)
+				innerBody = append([]byte{}, Body(w)...)
+				return test.innerStatus
+			}, AfterResponse, contentHandler([]byte("body")))
+			outer := headerHandler(func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+				outerRW = w.(*responseWriter)
+				outerBody = append([]byte{}, Body(w)...)
+				return statusCode
+			}, AfterResponse, inner)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			outer.ServeHTTP(w, r)
+
+			is.True(innerRW.bufferShared)
+			is.True(innerRW.bodyBuf == outerRW.bodyBuf) // only one buffer
+			is.Equal(string(innerBody), "body")
+			is.Equal(string(outerBody), test.wantBody)
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Body.String(), test.wantBody)
+		})
+	}
+}
+
+func TestHeaderHandler_AfterResponse_Nested_MaxBufferSize(t *testing.T) {
+	is := is.New(t)
+
+	var innerRW *responseWriter
+	inner := headerHandlerOpts(func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+		innerRW = w.(*responseWriter)
+		return statusCode
+	}, nil, AfterResponse, contentHandler([]byte("body")), &options{maxBufferSize: 1024})
+	outer := headerHandler(func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+		return statusCode
+	}, AfterResponse, inner)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	outer.ServeHTTP(w, r)
+
+	is.True(!innerRW.bufferShared)
+	is.Equal(w.Body.String(), "body")
+}
+
+func TestResponseWriter_NewBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength string
+		initialCap    int
+		maxBufferSize int
+		wantCap       int
+	}{
+		{
+			name:    "default",
+			wantCap: 0,
+		},
+		{
+			name:       "initial capacity",
+			initialCap: 512,
+			wantCap:    512,
+		},
+		{
+			name:          "content length",
+			contentLength: "1000",
+			initialCap:    512,
+			wantCap:       1000,
+		},
+		{
+			name:          "content length exceeds max buffer size",
+			contentLength: "1000",
+			initialCap:    512,
+			maxBufferSize: 100,
+			wantCap:       512,
+		},
+		{
+			name:          "invalid content length",
+			contentLength: "foo",
+			wantCap:       0,
+		},
+		{
+			name:          "huge content length",
+			contentLength: "1000000000000000",
+			wantCap:       maxContentLengthBufferCap,
+		},
+		{
+			name:          "large content length",
+			contentLength: "1000000",
+			initialCap:    512,
+			maxBufferSize: 2000000,
+			wantCap:       maxContentLengthBufferCap,
+		},
+		{
+			name:          "large content length with large initial capacity",
+			contentLength: "1000000",
+			initialCap:    200000,
+			wantCap:       200000,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			rw := &responseWriter{
+				w:                httptest.NewRecorder(),
+				initialBufferCap: test.initialCap,
+				maxBufferSize:    test.maxBufferSize,
+			}
+			if test.contentLength != "" {
+				rw.Header().Set("Content-Length", test.contentLength)
+			}
+
+			is.Equal(rw.newBuffer().Cap(), test.wantCap)
+		})
+	}
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince_RequestParseError(t *testing.T) {
+func TestHeaderHandler_AfterResponse_InitialBufferCapacity(t *testing.T) {
 	is := is.New(t)
 
-	loc, _ := time.LoadLocation("GMT")
-	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "Last-Modified", time.Now().In(loc).Format(time.RFC1123)))
+	body := []byte("body")
+	h := headerHandlerOpts(
+		func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+			return statusCode
+		},
+		nil, AfterResponse, contentHandler(body), newOptions([]Option{WithInitialBufferCapacity(1024)}))
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
-	r.Header.Set("If-Modified-Since", "bad")
 
 	h.ServeHTTP(w, r)
 
-	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Body.Bytes(), body)
 }
 
-func TestIfNoneMatchIfModifiedSinceHandler_IfModifiedSince_ResponseParseError(t *testing.T) {
+func TestHeaderHandler_AfterResponse_InaccurateContentLength(t *testing.T) {
 	is := is.New(t)
 
-	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "Last-Modified", "bad"))
+	h := headerHandlerOpts(
+		func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+			return statusCode
+		},
+		nil, AfterResponse, contentHandler([]byte("x"), "Content-Length", "1000000000000000"), &options{})
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
-	loc, _ := time.LoadLocation("GMT")
-	r.Header.Set("If-Modified-Since", time.Now().In(loc).Format(time.RFC1123))
 
 	h.ServeHTTP(w, r)
 
-	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Body.String(), "x")
+	is.Equal(w.Result().Header.Get("Content-Length"), "1")
 }
 
-func TestHeaderHandler_BeforeHeaders(t *testing.T) {
+func TestHeaderHandler_AfterResponse_ChangeStatus(t *testing.T) {
 	is := is.New(t)
 
 	fCalled := false
-	f := func(_ http.ResponseWriter, r *http.Request, statusCode int) int {
+	var headerValue string
+	var bodyContent []byte
+	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
 		fCalled = true
-		return statusCode
+		headerValue = w.Header().Get("X-Test")
+		bodyContent = Body(w)
+		return http.StatusCreated
 	}
 	body := []byte("body")
-	h := headerHandler(f, BeforeHeaders, contentHandler(body, "X-Test", "testValue"))
+	h := headerHandler(f, AfterResponse, contentHandler(body, "X-Test", "testValue"))
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
 	h.ServeHTTP(w, r)
 
 	is.True(fCalled)
-	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().StatusCode, http.StatusCreated)
+	is.Equal(headerValue, "testValue")
+	is.Equal(bodyContent, body)
 	b, _ := io.ReadAll(w.Result().Body)
 	is.Equal(b, body)
 }
 
-func TestHeaderHandler_AfterHeaders(t *testing.T) {
+func TestHeaderHandler_AfterResponse_Chunked(t *testing.T) {
 	is := is.New(t)
 
-	fCalled := false
-	var headerValue string
-	var bodyContent []byte
 	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
-		fCalled = true
-		headerValue = w.Header().Get("X-Test")
-		bodyContent = Body(w)
 		return statusCode
 	}
-	body := []byte("body")
-	h := headerHandler(f, AfterHeaders, contentHandler(body, "X-Test", "testValue"))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Transfer-Encoding", "chunked")
+		_, _ = w.Write([]byte("foo"))
+		_, _ = w.Write([]byte("bar"))
+	})
+	h := headerHandler(f, AfterResponse, next)
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
 	h.ServeHTTP(w, r)
 
-	is.True(fCalled)
 	is.Equal(w.Result().StatusCode, http.StatusOK)
-	is.Equal(headerValue, "testValue")
-	is.True(bodyContent == nil)
+	is.Equal(w.Result().Header.Get("Content-Length"), "6")
+	is.Equal(w.Result().Header.Get("Transfer-Encoding"), "")
 	b, _ := io.ReadAll(w.Result().Body)
-	is.Equal(b, body)
+	is.Equal(b, []byte("foobar"))
 }
 
-func TestHeaderHandler_AfterHeaders_NoContent(t *testing.T) {
+func TestIfNoneMatchIfModifiedSinceHandler_Chunked(t *testing.T) {
 	is := is.New(t)
 
-	fCalled := false
-	var bodyContent []byte
-	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
-		fCalled = true
-		bodyContent = Body(w)
-		return statusCode
-	}
-	h := headerHandler(f, AfterHeaders, noContentHandler())
+	h := IfNoneMatchIfModifiedSinceHandler(false, contentHandler([]byte("body"),
+		"ETag", ETag{Tag: "foo"}.String(), "Transfer-Encoding", "chunked"))
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", ETag{Tag: "foo"}.String())
 
 	h.ServeHTTP(w, r)
 
-	is.True(fCalled)
-	is.Equal(w.Result().StatusCode, http.StatusNoContent)
-	is.True(bodyContent == nil)
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+	is.Equal(w.Body.Len(), 0)
 }
 
-func TestHeaderHandler_AfterHeaders_ChangeStatus(t *testing.T) {
+func TestIfNoneMatchIfModifiedSinceHandler_Concurrent(t *testing.T) {
 	is := is.New(t)
 
-	fCalled := false
-	var headerValue string
-	var bodyContent []byte
-	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
-		fCalled = true
-		headerValue = w.Header().Get("X-Test")
-		bodyContent = Body(w)
-		return http.StatusCreated
+	modTime := time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC)
+	cache := NewETagCache(4)
+
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	})
+	h = ETagHandler(ETagFromBody(WithETagCache(cache), WithCacheIdentityFunc(func(w http.ResponseWriter, r *http.Request) (CacheIdentity, bool) {
+		return CacheIdentity{Key: r.URL.Path, Size: int64(len(Body(w))), ModTime: modTime}, true
+	})), AfterResponse, h)
+	h, err := LastModifiedHandlerConstant(modTime, h)
+	is.NoErr(err)
+	h = IfNoneMatchIfModifiedSinceHandler(true, h)
+
+	const goroutines = 16
+	const requests = 200
+
+	var wg sync.WaitGroup
+	failures := make(chan string, goroutines*requests)
+
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < requests; i++ {
+				path := "/" + strconv.Itoa((g+i)%8)
+				eTag := StableETag([]byte(path)).String()
+
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				wantStatus := http.StatusOK
+				if i%2 == 0 {
+					r.Header.Set("If-None-Match", eTag)
+					wantStatus = http.StatusNotModified
+				}
+
+				h.ServeHTTP(w, r)
+
+				if w.Result().StatusCode != wantStatus || w.Result().Header.Get("ETag") != eTag {
+					failures <- path
+				}
+			}
+		}(g)
 	}
-	body := []byte("body")
-	h := headerHandler(f, AfterHeaders, contentHandler(body, "X-Test", "testValue"))
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
-	h.ServeHTTP(w, r)
+	wg.Wait()
+	close(failures)
 
-	is.True(fCalled)
-	is.Equal(w.Result().StatusCode, http.StatusCreated)
-	is.Equal(headerValue, "testValue")
-	is.True(bodyContent == nil)
-	b, _ := io.ReadAll(w.Result().Body)
-	is.Equal(b, body)
+	is.Equal(len(failures), 0)
 }
 
-func TestHeaderHandler_AfterResponse(t *testing.T) {
+func contentHandler(b []byte, headerKV ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < len(headerKV); i += 2 {
+			w.Header().Set(headerKV[i], headerKV[i+1])
+		}
+		_, _ = w.Write(b)
+	})
+}
+
+// serverResponse sends a request with method and the headers in headerKV to h, served by a real HTTP server,
+// and returns the response together with its entire body.
+func serverResponse(t *testing.T, h http.Handler, method string, headerKV ...string) (*http.Response, []byte) {
+	t.Helper()
+
 	is := is.New(t)
 
-	fCalled := false
-	var headerValue string
-	var bodyContent []byte
-	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
-		fCalled = true
-		headerValue = w.Header().Get("X-Test")
-		bodyContent = Body(w)
-		return statusCode
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	req, err := http.NewRequest(method, s.URL, nil)
+	is.NoErr(err)
+	for i := 0; i < len(headerKV); i += 2 {
+		req.Header.Set(headerKV[i], headerKV[i+1])
 	}
+
+	res, err := s.Client().Do(req)
+	is.NoErr(err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	b, err := io.ReadAll(res.Body)
+	is.NoErr(err)
+
+	return res, b
+}
+
+func noContentHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "No Content", http.StatusNoContent)
+	})
+}
+
+// TestRoundTrip sends a request to each handler, replays the entity-tag produced by it as the If-None-Match
+// header of a second request, and asserts that the second request is answered with 304 Not Modified.
+func TestRoundTrip(t *testing.T) {
 	body := []byte("body")
-	h := headerHandler(f, AfterResponse, contentHandler(body, "X-Test", "testValue"))
+	constETag := func(e ETag) ETagFunc {
+		return func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+			return e, true
+		}
+	}
+	withINM := func(f ETagFunc, rm ResponseMode, opts ...Option) http.Handler {
+		return IfNoneMatchIfModifiedSinceHandler(true, ETagHandler(f, rm, contentHandler(body, "Content-Type", "text/plain"), opts...),
+			opts...)
+	}
+
+	tests := []struct {
+		name string
+		h    http.Handler
+	}{
+		{name: "StableETag", h: withINM(constETag(StableETag(body)), BeforeHeaders)},
+		{name: "quoted tag", h: withINM(constETag(ETag{Tag: `"foo"`}), BeforeHeaders)},
+		{name: "weak tag", h: withINM(constETag(ETag{Tag: "foo", Weak: true}), BeforeHeaders)},
+		{name: "escaped quotes", h: withINM(constETag(ETag{Tag: `fo"o`}), BeforeHeaders, WithEscapedQuotes(true))},
+		{name: "custom header name", h: withINM(constETag(ETag{Tag: "foo"}), BeforeHeaders, WithETagHeaderName("X-ETag"))},
+		{name: "ETagFromBody", h: withINM(ETagFromBody(), AfterResponse)},
+		{name: "ETagFromBody decoded", h: withINM(ETagFromBody(WithDecodeContentEncoding(true)), AfterResponse)},
+		{name: "ETagFromResponse", h: withINM(ETagFromResponse(), AfterResponse)},
+		{name: "WeakETagFromPrefix", h: withINM(WeakETagFromPrefix(2, sha256.New), AfterResponse)},
+		{name: "WeakETagFromHeaders", h: withINM(WeakETagFromHeaders("Content-Type"), AfterHeaders)},
+		{name: "fingerprint", h: withINM(nil, BeforeHeaders, WithFingerprint(func(r *http.Request) (string, bool) {
+			return "fingerprint", true
+		}))},
+		{
+			name: "ETagFromJSONHeader",
+			h: IfNoneMatchIfModifiedSinceHandler(true, ETagHandler(ETagFromJSONHeader("X-Meta"), AfterHeaders,
+				contentHandler(body, "X-Meta", `{"etag":"abc","weak":true}`))),
+		},
+		{
+			name: "ServeWithValidators",
+			h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ServeWithValidators(w, r, body, StableETag(body), time.Time{}, true)
+			}),
+		},
+		{
+			name: "ServeContent",
+			h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ServeContent(w, r, "body.txt", time.Time{}, strings.NewReader(string(body)))
+			}),
+		},
+		{
+			name: "PrecomputeHandler",
+			h: PrecomputeHandler(func(r *http.Request) (ETag, time.Time, PrecomputeResult) {
+				return StableETag(body), time.Time{}, PrecomputeAvailable
+			}, contentHandler(body)),
+		},
+		{name: "ETagTrailerHandler", h: IfNoneMatchIfModifiedSinceHandler(true, ETagTrailerHandler(contentHandler(body, "Content-Length", "4")))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			test.h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			eTag := w.Result().Header.Get("ETag")
+			if eTag == "" {
+				eTag = w.Result().Header.Get("X-ETag")
+			}
+			is.True(eTag != "")
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", eTag)
+
+			test.h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotModified)
+		})
+	}
+}
+
+func TestETagHandler_InvalidETag(t *testing.T) {
+	is := is.New(t)
+
+	var errs []error
+	h := ETagHandler(func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		return ETag{Tag: `fo"o`}, true
+	}, BeforeHeaders, contentHandler([]byte("body")), WithErrorFunc(func(err error, r *http.Request) {
+		errs = append(errs, err)
+	}))
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
 	h.ServeHTTP(w, r)
 
-	is.True(fCalled)
 	is.Equal(w.Result().StatusCode, http.StatusOK)
-	is.Equal(headerValue, "testValue")
-	is.Equal(bodyContent, body)
-	b, _ := io.ReadAll(w.Result().Body)
-	is.Equal(b, body)
+	is.Equal(w.Result().Header.Get("ETag"), "")
+	is.Equal(errs, []error{ErrInvalidETag})
 }
 
-func TestHeaderHandler_AfterResponse_ChangeStatus(t *testing.T) {
-	is := is.New(t)
+func BenchmarkIfNoneMatchIfModifiedSinceHandler_ChainedExpensiveFunc(b *testing.B) {
+	h := headerHandler(
+		func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+			expensiveFunc(w, r)
+			return statusCode
+		},
+		AfterHeaders, contentHandler([]byte{}, "ETag", ETag{Tag: "foo"}.String()))
+	h = IfNoneMatchIfModifiedSinceHandler(true, h)
 
-	fCalled := false
-	var headerValue string
-	var bodyContent []byte
+	benchmarkNotModified(b, h)
+}
+
+func BenchmarkIfNoneMatchIfModifiedSinceHandler_FullResponseFuncs(b *testing.B) {
+	h := IfNoneMatchIfModifiedSinceHandler(true, contentHandler([]byte{}, "ETag", ETag{Tag: "foo"}.String()),
+		WithFullResponseFuncs(expensiveFunc))
+
+	benchmarkNotModified(b, h)
+}
+
+func BenchmarkTryMatchETag(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		inm  string
+	}{
+		{
+			name: "echo",
+			inm:  `"foo"`,
+		},
+		{
+			name: "list",
+			inm:  `"bar", "foo"`,
+		},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			o := &options{}
+			w := httptest.NewRecorder()
+			w.Header().Set("ETag", `"foo"`)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", bm.inm)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if statusCode, _, _ := tryMatchETag(w, r, o, true, http.StatusOK); statusCode != http.StatusNotModified {
+					b.Fatalf("unexpected status code: %d", statusCode)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHeaderHandler_AfterResponse_Nested(b *testing.B) {
 	f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
-		fCalled = true
-		headerValue = w.Header().Get("X-Test")
-		bodyContent = Body(w)
-		return http.StatusCreated
+		return statusCode
 	}
-	body := []byte("body")
-	h := headerHandler(f, AfterResponse, contentHandler(body, "X-Test", "testValue"))
-	w := httptest.NewRecorder()
+	h := headerHandler(f, AfterResponse, headerHandler(f, AfterResponse, contentHandler(make([]byte, 64*1024))))
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
-	h.ServeHTTP(w, r)
+	b.ReportAllocs()
+	b.ResetTimer()
 
-	is.True(fCalled)
-	is.Equal(w.Result().StatusCode, http.StatusCreated)
-	is.Equal(headerValue, "testValue")
-	is.Equal(bodyContent, body)
-	b, _ := io.ReadAll(w.Result().Body)
-	is.Equal(b, body)
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
 }
 
-func contentHandler(b []byte, headerKV ...string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		for i := 0; i < len(headerKV); i += 2 {
-			w.Header().Set(headerKV[i], headerKV[i+1])
+func BenchmarkHeaderHandler_AfterResponse_BufferCapacity(b *testing.B) {
+	benchmarks := []struct {
+		name          string
+		contentLength bool
+	}{
+		{
+			name: "unknown length",
+		},
+		{
+			name:          "content length",
+			contentLength: true,
+		},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			chunk := make([]byte, 4096)
+			f := func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+				return statusCode
+			}
+			h := headerHandler(f, AfterResponse, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if bm.contentLength {
+					w.Header().Set("Content-Length", strconv.Itoa(16*len(chunk)))
+				}
+				// write in chunks so that an unsized buffer grows repeatedly
+				for i := 0; i < 16; i++ {
+					_, _ = w.Write(chunk)
+				}
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(httptest.NewRecorder(), r)
+			}
+		})
+	}
+}
+
+func BenchmarkETag_String(b *testing.B) {
+	e := StableETag([]byte("foo"))
+	e.Weak = true
+
+	b.Run("String", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			_ = e.String()
 		}
-		_, _ = w.Write(b)
 	})
-}
 
-func noContentHandler() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "No Content", http.StatusNoContent)
+	b.Run("AppendTo", func(b *testing.B) {
+		buf := make([]byte, 0, 128)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			buf = e.AppendTo(buf[:0])
+		}
+	})
+
+	b.Run("WriteTo", func(b *testing.B) {
+		buf := bytes.Buffer{}
+		buf.Grow(128)
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			_, _ = e.WriteTo(&buf)
+		}
 	})
 }
+
+func benchmarkNotModified(b *testing.B, h http.Handler) {
+	b.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", ETag{Tag: "foo"}.String())
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusNotModified {
+			b.Fatalf("unexpected status code: %d", w.Code)
+		}
+	}
+}
+
+func expensiveFunc(w http.ResponseWriter, _ *http.Request) {
+	sum := sha256.Sum256(make([]byte, 64*1024))
+	w.Header().Set("X-Expensive", hex.EncodeToString(sum[:]))
+}
+
+func lastModifiedFunc(t time.Time, ok bool) LastModifiedFunc {
+	return func(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+		return t, ok
+	}
+}
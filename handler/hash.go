@@ -0,0 +1,342 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sha256Algo is the name of the SHA-256 algorithm passed to the function configured using WithETagFormatter.
+const sha256Algo = "sha256"
+
+// StableETag returns a strong entity-tag derived deterministically from input, using SHA-256.
+//
+// The resulting entity-tag depends on input only, and does not contain any process-specific data,
+// such as pointer addresses, random salts, or start-up timestamps. It is therefore stable across
+// process restarts and across multiple instances of the same application, which allows shared caches
+// to revalidate responses successfully. Entity-tags generated from values that change on restart
+// should be avoided for that reason.
+func StableETag(input []byte) ETag {
+	sum := sha256.Sum256(input)
+	return ETag{
+		Tag: hex.EncodeToString(sum[:]),
+	}
+}
+
+// ETagFromValue returns a strong entity-tag derived deterministically from v, in the same way as StableETag.
+// v is encoded using encoding/json, which encodes struct fields in declaration order and sorts map keys,
+// so that equal values always produce equal entity-tags, across process restarts as well. This allows
+// producing entity-tags for API resources without buffering the rendered response body, for example
+// in an ETagFunc used with the BeforeHeaders response mode.
+//
+// If v cannot be encoded, an error is returned.
+func ETagFromValue(v interface{}) (ETag, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ETag{}, err
+	}
+
+	return StableETag(b), nil
+}
+
+// CollectionETag returns a strong entity-tag for a collection of resources, derived deterministically from
+// the entity-tags of its members, in the same way as StableETag. The order of members does not matter, so that
+// the entity-tag of a collection stays the same if its members are returned in a different order, while adding,
+// removing, or changing any member changes it. This allows answering conditional requests for list and batch
+// endpoints by comparing the request's If-None-Match header with the collection's entity-tag, for example using
+// PrecomputeHandler or RequestNotModified.
+func CollectionETag(members []ETag) ETag {
+	strs := make([]string, len(members))
+	for i, m := range members {
+		s := m.String()
+		// tags are supplied by the caller and may contain double quotes, for example when using
+		// WithEscapedQuotes, so members are length-prefixed to be concatenated unambiguously
+		strs[i] = strconv.Itoa(len(s)) + ":" + s
+	}
+	sort.Strings(strs)
+
+	return StableETag([]byte(strings.Join(strs, "")))
+}
+
+// FingerprintFunc returns a fingerprint of r's response's representation, such as a template name combined with
+// a hash of the template's arguments. Fingerprints should be cheap to produce, and must change whenever the
+// representation changes. If the function cannot produce a fingerprint, it returns ok==false.
+type FingerprintFunc func(r *http.Request) (string, bool)
+
+// ETagFromBody returns an ETagFunc that produces a strong entity-tag from a SHA-256 hash of the response body,
+// in the same way as StableETag. It must be used with the AfterResponse response mode. The request method is not
+// part of the hash, so HEAD and GET requests produce the same entity-tag if next writes the body for both.
+//
+// If the body is not available, such as when buffering has been abandoned because of WithMaxBufferSize, or if
+// nothing has been written for a HEAD request or for a response whose status code does not allow a body,
+// the function returns ok==false. If both an ETagCache and a CacheIdentityFunc are configured, entity-tags are
+// cached. See the package documentation for entity-tags of compressed bodies.
+func ETagFromBody(opts ...Option) ETagFunc {
+	o := newOptions(opts)
+
+	return func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		b, ok := bufferedBody(w)
+		if !ok {
+			return ETag{}, false
+		}
+
+		if o.eTagCache == nil || o.cacheIdentityFunc == nil {
+			return bodyETag(w, b, o)
+		}
+
+		id, ok := o.cacheIdentityFunc(w, r)
+		if !ok {
+			return bodyETag(w, b, o)
+		}
+
+		id, ok = varyIdentity(id, w.Header(), r)
+		if !ok {
+			return bodyETag(w, b, o)
+		}
+
+		if e, ok := o.eTagCache.Get(id); ok {
+			if o.contentDigest {
+				sum := sha256.Sum256(b)
+				setContentDigest(w.Header(), sum[:])
+			}
+			return e, true
+		}
+
+		e, ok := bodyETag(w, b, o)
+		if ok {
+			o.eTagCache.Put(id, e)
+		}
+		return e, ok
+	}
+}
+
+// bodyETag returns the entity-tag for w's body b, decoding b first if configured in o.
+// If configured in o, the Content-Digest header is set from the hash of b.
+func bodyETag(w http.ResponseWriter, b []byte, o *options) (ETag, bool) {
+	sum := sha256.Sum256(b)
+	if o.contentDigest {
+		setContentDigest(w.Header(), sum[:])
+	}
+
+	if !o.decodeContentEncoding {
+		if coding := contentCoding(w.Header()); coding != "" {
+			// include the content coding in the hash domain, so that strong entity-tags of different
+			// content codings never collide
+			h := sha256.New()
+			_, _ = io.WriteString(h, coding+"\x00")
+			_, _ = h.Write(b)
+			return o.digestETag(sha256Algo, h.Sum(nil)), true
+		}
+
+		return o.digestETag(sha256Algo, sum[:]), true
+	}
+
+	b, ok := decodeContent(b, w.Header().Get("Content-Encoding"))
+	if !ok {
+		return ETag{}, false
+	}
+
+	sum = sha256.Sum256(b)
+	e := o.digestETag(sha256Algo, sum[:])
+	e.Weak = true
+	return e, true
+}
+
+// contentCoding returns the normalized content coding of header's Content-Encoding header, or the empty string
+// for the identity coding.
+func contentCoding(header http.Header) string {
+	coding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if coding == "identity" {
+		return ""
+	}
+	return coding
+}
+
+// setContentDigest sets the Content-Digest header in header to the SHA-256 hash sum, according to RFC 9530.
+func setContentDigest(header http.Header, sum []byte) {
+	header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+}
+
+// decodeContent decodes b according to the content coding encoding, which is the value of a Content-Encoding header.
+// Only gzip and deflate are supported.
+func decodeContent(b []byte, encoding string) ([]byte, bool) {
+	var r io.ReadCloser
+	var err error
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return b, true
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	default:
+		return nil, false
+	}
+
+	if err != nil {
+		return nil, false
+	}
+
+	defer func() {
+		_ = r.Close()
+	}()
+
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+
+	return decoded, true
+}
+
+// WeakETagFromPrefix returns an ETagFunc that produces a weak entity-tag from the total length of the response body,
+// combined with a hash of the body's first n bytes, using a hash produced by hash. This is cheaper than hashing
+// the entire body for large bodies. It must be used with the AfterResponse response mode. If the response body
+// is not available, such as when buffering has been abandoned because of WithMaxBufferSize, the function
+// returns ok==false.
+//
+// Note that changes to the body beyond its first n bytes are not detected if they do not change the body's length.
+// Clients and caches may then keep using outdated representations. WeakETagFromPrefix should therefore only be used
+// if such changes are unlikely or acceptable, for example with append-only content, or content that carries
+// a version number near its start.
+func WeakETagFromPrefix(n int, hash func() hash.Hash) ETagFunc {
+	return func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		b, ok := bufferedBody(w)
+		if !ok {
+			return ETag{}, false
+		}
+
+		prefix := b
+		if len(prefix) > n {
+			prefix = prefix[:n]
+		}
+
+		h := hash()
+		_, _ = h.Write(prefix)
+
+		return ETag{
+			Tag:  strconv.Itoa(len(b)) + "-" + hex.EncodeToString(h.Sum(nil)),
+			Weak: true,
+		}, true
+	}
+}
+
+// ETagFromResponse returns an ETagFunc that produces a strong entity-tag from a SHA-256 hash of both the response
+// headers and the response body. It must be used with the AfterResponse response mode. If the response body
+// is not available, such as when buffering has been abandoned because of WithMaxBufferSize, the function
+// returns ok==false.
+//
+// Headers that change with every response even though the representation does not, such as Date, must not be
+// included in the hash, otherwise the entity-tag would change every time. Headers to exclude from the hash can be
+// configured using WithHashExcludedHeaders. The ETag header itself is always excluded.
+//
+// As with ETagFromBody, the request method is not part of the hash, so HEAD and GET requests for the same resource
+// produce the same entity-tag.
+func ETagFromResponse(opts ...Option) ETagFunc {
+	o := newOptions(opts)
+
+	excluded := map[string]struct{}{
+		http.CanonicalHeaderKey(o.eTagHeader()): {},
+	}
+	for _, h := range o.hashExcludedHeaders() {
+		excluded[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		b, ok := bufferedBody(w)
+		if !ok {
+			return ETag{}, false
+		}
+
+		h := sha256.New()
+		writeHeader(h, w.Header(), excluded)
+		_, _ = h.Write(b)
+
+		return o.digestETag(sha256Algo, h.Sum(nil)), true
+	}
+}
+
+// WeakETagFromHeaders returns an ETagFunc that produces a weak entity-tag from a SHA-256 hash of the response
+// headers listed in names, such as Content-Language, ignoring the body entirely. This is a cheap validator for
+// negotiated content that only varies by those headers. It must be used with the AfterHeaders, HeadersReady,
+// or AfterResponse response modes. The order of names is irrelevant, and the same header values always produce
+// the same entity-tag. If the response contains none of the headers, the function returns ok==false.
+//
+// Since the body is not hashed, changes to the body are not detected unless they also change any of the headers.
+func WeakETagFromHeaders(names ...string) ETagFunc {
+	canonical := make([]string, 0, len(names))
+	seen := map[string]struct{}{}
+	for _, n := range names {
+		n = http.CanonicalHeaderKey(n)
+		if _, ok := seen[n]; ok {
+			continue
+		}
+		seen[n] = struct{}{}
+		canonical = append(canonical, n)
+	}
+	sort.Strings(canonical)
+
+	return func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+		h := sha256.New()
+		found := false
+		for _, name := range canonical {
+			for _, v := range w.Header().Values(name) {
+				found = true
+				_, _ = io.WriteString(h, name+": "+v+"\r\n")
+			}
+		}
+
+		if !found {
+			return ETag{}, false
+		}
+
+		return ETag{
+			Tag:  hex.EncodeToString(h.Sum(nil)),
+			Weak: true,
+		}, true
+	}
+}
+
+// writeHeader writes header to w in wire format, in a deterministic order, skipping headers listed in excluded.
+func writeHeader(w io.Writer, header http.Header, excluded map[string]struct{}) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		if _, ok := excluded[http.CanonicalHeaderKey(name)]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, v := range header[name] {
+			_, _ = io.WriteString(w, http.CanonicalHeaderKey(name)+": "+v+"\r\n")
+		}
+	}
+	_, _ = io.WriteString(w, "\r\n")
+}
+
+func fingerprintETag(fp string) ETag {
+	e := StableETag([]byte(fp))
+	e.Weak = true
+	return e
+}
+
+func readerETag(r io.Reader, o *options) (ETag, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return ETag{}, err
+	}
+
+	return o.digestETag(sha256Algo, h.Sum(nil)), nil
+}
@@ -0,0 +1,863 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestStableETag(t *testing.T) {
+	is := is.New(t)
+
+	e1 := StableETag([]byte("foo"))
+	e2 := StableETag([]byte("foo"))
+
+	is.Equal(e1, e2)
+	is.True(!e1.Weak)
+	is.Equal(e1.Tag, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae")
+}
+
+func TestStableETag_Different(t *testing.T) {
+	is := is.New(t)
+	is.True(StableETag([]byte("foo")) != StableETag([]byte("bar")))
+}
+
+func TestETagFromValue(t *testing.T) {
+	type resource struct {
+		Name  string
+		Attrs map[string]int
+	}
+
+	tests := []struct {
+		name      string
+		a         interface{}
+		b         interface{}
+		wantEqual bool
+	}{
+		{
+			name:      "equal",
+			a:         resource{Name: "foo", Attrs: map[string]int{"a": 1}},
+			b:         resource{Name: "foo", Attrs: map[string]int{"a": 1}},
+			wantEqual: true,
+		},
+		{
+			name:      "different",
+			a:         resource{Name: "foo"},
+			b:         resource{Name: "bar"},
+			wantEqual: false,
+		},
+		{
+			name:      "map ordering",
+			a:         resource{Name: "foo", Attrs: map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}},
+			b:         resource{Name: "foo", Attrs: map[string]int{"e": 5, "d": 4, "c": 3, "b": 2, "a": 1}},
+			wantEqual: true,
+		},
+		{
+			name:      "map values",
+			a:         map[string]int{"a": 1, "b": 2},
+			b:         map[string]int{"a": 2, "b": 1},
+			wantEqual: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			a, err := ETagFromValue(test.a)
+			is.NoErr(err)
+			is.True(!a.Weak)
+
+			for i := 0; i < 10; i++ {
+				b, err := ETagFromValue(test.b)
+				is.NoErr(err)
+				is.Equal(a == b, test.wantEqual)
+			}
+		})
+	}
+}
+
+func TestETagFromValue_Error(t *testing.T) {
+	is := is.New(t)
+
+	_, err := ETagFromValue(make(chan int))
+	is.True(err != nil)
+}
+
+func TestCollectionETag(t *testing.T) {
+	members := []ETag{{Tag: "foo"}, {Tag: "bar"}, {Tag: "baz", Weak: true}}
+
+	tests := []struct {
+		name      string
+		members   []ETag
+		wantEqual bool
+	}{
+		{
+			name:      "same order",
+			members:   []ETag{{Tag: "foo"}, {Tag: "bar"}, {Tag: "baz", Weak: true}},
+			wantEqual: true,
+		},
+		{
+			name:      "reordered",
+			members:   []ETag{{Tag: "baz", Weak: true}, {Tag: "foo"}, {Tag: "bar"}},
+			wantEqual: true,
+		},
+		{
+			name:    "changed member",
+			members: []ETag{{Tag: "foo"}, {Tag: "qux"}, {Tag: "baz", Weak: true}},
+		},
+		{
+			name:    "changed weakness",
+			members: []ETag{{Tag: "foo"}, {Tag: "bar"}, {Tag: "baz"}},
+		},
+		{
+			name:    "added member",
+			members: []ETag{{Tag: "foo"}, {Tag: "bar"}, {Tag: "baz", Weak: true}, {Tag: "qux"}},
+		},
+		{
+			name:    "removed member",
+			members: []ETag{{Tag: "foo"}, {Tag: "bar"}},
+		},
+		{
+			name:    "merged members",
+			members: []ETag{{Tag: "foobar"}, {Tag: "baz", Weak: true}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			e := CollectionETag(test.members)
+
+			is.True(!e.Weak)
+			is.Equal(e == CollectionETag(members), test.wantEqual)
+		})
+	}
+}
+
+func TestCollectionETag_QuotesInTags(t *testing.T) {
+	is := is.New(t)
+
+	e := CollectionETag([]ETag{{Tag: `a""b`}})
+	is.True(e != CollectionETag([]ETag{{Tag: "a"}, {Tag: "b"}}))
+}
+
+func TestETagFromBody(t *testing.T) {
+	is := is.New(t)
+
+	body := []byte("body")
+	h := ETagHandler(ETagFromBody(), AfterResponse, contentHandler(body))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), StableETag(body).String())
+}
+
+func TestETagFromBody_Empty(t *testing.T) {
+	tests := []struct {
+		name string
+		next http.Handler
+	}{
+		{
+			name: "WriteHeader only",
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		},
+		{
+			name: "empty write",
+			next: contentHandler([]byte{}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := ETagHandler(ETagFromBody(), AfterResponse, test.next)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte{}).String())
+			is.Equal(w.Result().Header.Get("ETag"), `"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"`)
+		})
+	}
+}
+
+func TestETagFromBody_NoBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		next       http.Handler
+		wantStatus int
+		wantETag   string
+	}{
+		{
+			name:   "HEAD",
+			method: http.MethodHead,
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "4")
+			}),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "downstream 304",
+			method: http.MethodGet,
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"foo"`)
+				w.WriteHeader(http.StatusNotModified)
+			}),
+			wantStatus: http.StatusNotModified,
+			wantETag:   `"foo"`,
+		},
+		{
+			name:   "204",
+			method: http.MethodGet,
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}),
+			wantStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := ETagHandler(ETagFromBody(), AfterResponse, test.next)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(test.method, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+		})
+	}
+}
+
+func TestETagFromBody_FileServer(t *testing.T) {
+	is := is.New(t)
+
+	fsys := fstest.MapFS{
+		"foo.txt": &fstest.MapFile{
+			Data:    []byte("foo"),
+			ModTime: time.Now(),
+		},
+	}
+	h := ETagHandler(ETagFromBody(), AfterResponse, http.FileServer(http.FS(fsys)))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/foo.txt", nil))
+	is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("foo")).String())
+
+	// the file server does not write the body for HEAD requests, so no entity-tag can be produced from it
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/foo.txt", nil))
+	is.Equal(w.Result().Header.Get("ETag"), "")
+}
+
+func TestETagFromBody_Cache(t *testing.T) {
+	is := is.New(t)
+
+	cache := NewETagCache(10)
+	modTime := time.Now()
+	idFunc := func(w http.ResponseWriter, r *http.Request) (CacheIdentity, bool) {
+		return CacheIdentity{Key: r.URL.Path, Size: int64(len(Body(w))), ModTime: modTime}, true
+	}
+	f := ETagFromBody(WithETagCache(cache), WithCacheIdentityFunc(idFunc))
+
+	h := ETagHandler(f, AfterResponse, contentHandler([]byte("foo")))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("foo")).String())
+
+	// same identity, different content: cached entity-tag is used
+	h = ETagHandler(f, AfterResponse, contentHandler([]byte("bar")))
+	w = httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("foo")).String())
+
+	// changed identity: entity-tag is computed again
+	modTime = modTime.Add(time.Minute)
+	w = httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("bar")).String())
+}
+
+func TestWeakETagFromPrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		otherBody string
+		wantEqual bool
+	}{
+		{
+			name:      "same",
+			body:      "0123456789",
+			otherBody: "0123456789",
+			wantEqual: true,
+		},
+		{
+			name:      "prefix differs",
+			body:      "0123456789",
+			otherBody: "x123456789",
+			wantEqual: false,
+		},
+		{
+			name:      "tail differs",
+			body:      "0123456789",
+			otherBody: "012345678x",
+			wantEqual: true,
+		},
+		{
+			name:      "length differs",
+			body:      "0123456789",
+			otherBody: "01234567890",
+			wantEqual: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			eTag := func(body string) string {
+				h := ETagHandler(WeakETagFromPrefix(4, sha256.New), AfterResponse, contentHandler([]byte(body)))
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+				h.ServeHTTP(w, r)
+
+				return w.Result().Header.Get("ETag")
+			}
+
+			e := eTag(test.body)
+			is.True(strings.HasPrefix(e, `W/"10-`))
+			is.Equal(e == eTag(test.otherBody), test.wantEqual)
+		})
+	}
+}
+
+func TestETagFromResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		header    string
+		values    [2]string
+		wantEqual bool
+	}{
+		{
+			name:      "Date excluded",
+			header:    "Date",
+			values:    [2]string{"Wed, 09 Jun 2021 10:18:15 GMT", "Wed, 09 Jun 2021 10:18:16 GMT"},
+			wantEqual: true,
+		},
+		{
+			name:      "Set-Cookie excluded",
+			header:    "Set-Cookie",
+			values:    [2]string{"session=1", "session=2"},
+			wantEqual: true,
+		},
+		{
+			name:      "Content-Type included",
+			header:    "Content-Type",
+			values:    [2]string{"text/plain", "text/html"},
+			wantEqual: false,
+		},
+		{
+			name:      "custom excluded",
+			opts:      []Option{WithHashExcludedHeaders("X-Request-Id")},
+			header:    "X-Request-Id",
+			values:    [2]string{"1", "2"},
+			wantEqual: true,
+		},
+		{
+			name:      "custom replaces default",
+			opts:      []Option{WithHashExcludedHeaders("X-Request-Id")},
+			header:    "Date",
+			values:    [2]string{"Wed, 09 Jun 2021 10:18:15 GMT", "Wed, 09 Jun 2021 10:18:16 GMT"},
+			wantEqual: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			eTag := func(value string) string {
+				h := ETagHandler(ETagFromResponse(test.opts...), AfterResponse, contentHandler([]byte("body"), test.header, value))
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+				h.ServeHTTP(w, r)
+
+				return w.Result().Header.Get("ETag")
+			}
+
+			e := eTag(test.values[0])
+			is.True(e != "")
+			is.Equal(e == eTag(test.values[1]), test.wantEqual)
+		})
+	}
+}
+
+func TestETagFuncs_HeadGet(t *testing.T) {
+	tests := []struct {
+		name string
+		h    http.Handler
+	}{
+		{
+			name: "ETagFromBody",
+			h:    ETagHandler(ETagFromBody(), AfterResponse, contentHandler([]byte("body"))),
+		},
+		{
+			name: "ETagFromBody cache",
+			h: ETagHandler(ETagFromBody(
+				WithETagCache(NewETagCache(10)),
+				WithCacheIdentityFunc(func(w http.ResponseWriter, r *http.Request) (CacheIdentity, bool) {
+					return CacheIdentity{Key: r.URL.Path}, true
+				})), AfterResponse, contentHandler([]byte("body"))),
+		},
+		{
+			name: "ETagFromResponse",
+			h:    ETagHandler(ETagFromResponse(), AfterResponse, contentHandler([]byte("body"), "Content-Type", "text/plain")),
+		},
+		{
+			name: "ServeContent",
+			h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ServeContent(w, r, "body.txt", time.Time{}, strings.NewReader("body"))
+			}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			eTag := func(method string) string {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(method, "/", nil)
+
+				test.h.ServeHTTP(w, r)
+
+				return w.Result().Header.Get("ETag")
+			}
+
+			get := eTag(http.MethodGet)
+			is.True(get != "")
+			is.Equal(eTag(http.MethodHead), get)
+			is.Equal(eTag(http.MethodGet), get)
+		})
+	}
+}
+
+func TestETagFromBody_ContentDigest(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{
+			name: "uncached",
+		},
+		{
+			name: "cached",
+			opts: []Option{
+				WithETagCache(NewETagCache(10)),
+				WithCacheIdentityFunc(func(w http.ResponseWriter, r *http.Request) (CacheIdentity, bool) {
+					return CacheIdentity{Key: r.URL.Path}, true
+				}),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			body := []byte("body")
+			sum := sha256.Sum256(body)
+			wantDigest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+
+			opts := append([]Option{WithContentDigest(true)}, test.opts...)
+			h := ETagHandler(ETagFromBody(opts...), AfterResponse, contentHandler(body))
+
+			for i := 0; i < 2; i++ {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+				h.ServeHTTP(w, r)
+
+				is.Equal(w.Result().Header.Get("ETag"), `"`+hex.EncodeToString(sum[:])+`"`)
+				is.Equal(w.Result().Header.Get("Content-Digest"), wantDigest)
+			}
+		})
+	}
+}
+
+func TestETagFromBody_ContentDigest_Default(t *testing.T) {
+	is := is.New(t)
+
+	h := ETagHandler(ETagFromBody(), AfterResponse, contentHandler([]byte("body")))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.True(w.Result().Header.Get("ETag") != "")
+	is.Equal(w.Result().Header.Get("Content-Digest"), "")
+}
+
+func TestWeakETagFromHeaders(t *testing.T) {
+	eTag := func(f ETagFunc, body string, headerKV ...string) string {
+		h := ETagHandler(f, AfterHeaders, contentHandler([]byte(body), headerKV...))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		h.ServeHTTP(w, r)
+
+		return w.Result().Header.Get("ETag")
+	}
+
+	t.Run("listed header changes", func(t *testing.T) {
+		is := is.New(t)
+
+		f := WeakETagFromHeaders("Content-Language")
+		en := eTag(f, "body", "Content-Language", "en")
+		is.True(strings.HasPrefix(en, `W/"`))
+		is.Equal(eTag(f, "body", "Content-Language", "en"), en)
+		is.True(eTag(f, "body", "Content-Language", "de") != en)
+	})
+
+	t.Run("unlisted header and body ignored", func(t *testing.T) {
+		is := is.New(t)
+
+		f := WeakETagFromHeaders("content-language", "Content-Type")
+		e := eTag(f, "body", "Content-Language", "en", "Content-Type", "text/plain", "X-Foo", "1")
+		is.Equal(eTag(f, "other", "Content-Language", "en", "Content-Type", "text/plain", "X-Foo", "2"), e)
+		is.Equal(eTag(WeakETagFromHeaders("Content-Type", "Content-Language"), "body",
+			"Content-Language", "en", "Content-Type", "text/plain"), e)
+	})
+
+	t.Run("no listed headers", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(eTag(WeakETagFromHeaders("Content-Language"), "body"), "")
+	})
+}
+
+func TestETagFromBody_ETagFormatter(t *testing.T) {
+	is := is.New(t)
+
+	var algos []string
+	formatter := func(algo string, sum []byte) ETag {
+		algos = append(algos, algo)
+		return ETag{
+			Tag: algo + "-" + base64.StdEncoding.EncodeToString(sum),
+		}
+	}
+
+	body := []byte("body")
+	h := ETagHandler(ETagFromBody(WithETagFormatter(formatter)), AfterResponse, contentHandler(body))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	sum := sha256.Sum256(body)
+	is.Equal(w.Result().Header.Get("ETag"), `"sha256-`+base64.StdEncoding.EncodeToString(sum[:])+`"`)
+	is.Equal(algos, []string{"sha256"})
+}
+
+func TestETagFromBody_ETagFormatter_Decoded(t *testing.T) {
+	is := is.New(t)
+
+	formatter := func(algo string, sum []byte) ETag {
+		return ETag{
+			Tag: algo + "-" + hex.EncodeToString(sum),
+		}
+	}
+
+	h := ETagHandler(ETagFromBody(WithETagFormatter(formatter), WithDecodeContentEncoding(true)), AfterResponse,
+		contentHandler([]byte("body")))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	sum := sha256.Sum256([]byte("body"))
+	is.Equal(w.Result().Header.Get("ETag"), `W/"sha256-`+hex.EncodeToString(sum[:])+`"`)
+}
+
+func TestETagFromBody_DecodeContentEncoding(t *testing.T) {
+	body := []byte("body body body body")
+	content := contentHandler(body, "Content-Length", strconv.Itoa(len(body)))
+	f := ETagFromBody(WithDecodeContentEncoding(true))
+
+	tests := []struct {
+		name string
+		h    http.Handler
+	}{
+		{
+			name: "uncompressed",
+			h:    ETagHandler(f, AfterResponse, content),
+		},
+		{
+			name: "compression after",
+			h:    gzipHandler(ETagHandler(f, AfterResponse, content)),
+		},
+		{
+			name: "compression before",
+			h:    ETagHandler(f, AfterResponse, gzipHandler(content)),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			test.h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().Header.Get("ETag"), `W/"`+StableETag(body).Tag+`"`)
+
+			b := w.Body.Bytes()
+			if w.Result().Header.Get("Content-Encoding") == "gzip" {
+				gr, err := gzip.NewReader(bytes.NewReader(b))
+				is.NoErr(err)
+				b, err = io.ReadAll(gr)
+				is.NoErr(err)
+			} else {
+				is.Equal(w.Result().Header.Get("Content-Length"), strconv.Itoa(len(body)))
+			}
+			is.Equal(b, body)
+		})
+	}
+}
+
+func TestETagFromBody_EncodingMatrix(t *testing.T) {
+	body := []byte("body body body body")
+	content := contentHandler(body)
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantEqual bool
+		wantWeak  bool
+	}{
+		{
+			name:      "strong",
+			wantEqual: false,
+		},
+		{
+			name:      "weak",
+			opts:      []Option{WithDecodeContentEncoding(true)},
+			wantEqual: true,
+			wantWeak:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			eTag := func(h http.Handler) ETag {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+				h.ServeHTTP(w, r)
+
+				e, ok := eTagFromString(w.Result().Header.Get("ETag"))
+				is.True(ok)
+				return e
+			}
+
+			f := ETagFromBody(test.opts...)
+			identity := eTag(ETagHandler(f, AfterResponse, content))
+			gzipped := eTag(ETagHandler(f, AfterResponse, gzipHandler(content)))
+
+			is.Equal(identity == gzipped, test.wantEqual)
+			is.Equal(identity.Weak, test.wantWeak)
+			is.Equal(gzipped.Weak, test.wantWeak)
+		})
+	}
+}
+
+func TestETagFromBody_EncodingInHashDomain(t *testing.T) {
+	is := is.New(t)
+
+	body := []byte("body")
+	eTag := func(encoding string) string {
+		h := ETagHandler(ETagFromBody(), AfterResponse, contentHandler(body, "Content-Encoding", encoding))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		h.ServeHTTP(w, r)
+
+		return w.Result().Header.Get("ETag")
+	}
+
+	// identical bytes labeled with different content codings are different representations
+	is.Equal(eTag(""), StableETag(body).String())
+	is.Equal(eTag("identity"), StableETag(body).String())
+	is.True(eTag("br") != eTag(""))
+	is.True(eTag("br") != eTag("gzip"))
+}
+
+func TestETagFromBody_DecodeContentEncoding_Unsupported(t *testing.T) {
+	is := is.New(t)
+
+	h := ETagHandler(ETagFromBody(WithDecodeContentEncoding(true)), AfterResponse,
+		contentHandler([]byte("body"), "Content-Encoding", "br"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), "")
+}
+
+// gzipHandler is a minimal compression middleware that compresses all responses using gzip.
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gw := &gzipResponseWriter{
+			ResponseWriter: w,
+			gz:             gzip.NewWriter(w),
+		}
+		next.ServeHTTP(gw, r)
+		_ = gw.gz.Close()
+	})
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz            *gzip.Writer
+	headerWritten bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(statusCode int) {
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.gz.Write(b)
+}
+
+func TestETagHandler_Fingerprint(t *testing.T) {
+	is := is.New(t)
+
+	fp := "template1"
+	fpFunc := func(r *http.Request) (string, bool) {
+		return fp, true
+	}
+	h := ETagHandler(nil, BeforeHeaders, contentHandler([]byte{}), WithFingerprint(fpFunc))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	eTag1 := w.Result().Header.Get("ETag")
+	e, ok := eTagFromString(eTag1)
+	is.True(ok)
+	is.True(e.Weak)
+
+	fp = "template2"
+	w = httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	eTag2 := w.Result().Header.Get("ETag")
+	is.True(eTag2 != "")
+	is.True(eTag1 != eTag2)
+}
+
+func TestETagHandler_Fingerprint_NotOK(t *testing.T) {
+	is := is.New(t)
+
+	fpFunc := func(r *http.Request) (string, bool) {
+		return "", false
+	}
+	h := ETagHandler(nil, BeforeHeaders, contentHandler([]byte{}), WithFingerprint(fpFunc))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), "")
+}
+
+func TestETagFromBody_MaxBufferSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantETag string
+	}{
+		{
+			name:     "within limit",
+			body:     "foobar",
+			wantETag: StableETag([]byte("foobar")).String(),
+		},
+		{
+			name:     "exceeds limit",
+			body:     "foobarbaz",
+			wantETag: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < len(test.body); i += 3 {
+					_, _ = w.Write([]byte(test.body[i : i+3]))
+				}
+			})
+			h := ETagHandler(ETagFromBody(), AfterResponse, next, WithMaxBufferSize(6))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			is.Equal(w.Result().Header.Get("ETag"), test.wantETag)
+			b, _ := io.ReadAll(w.Result().Body)
+			is.Equal(string(b), test.body)
+		})
+	}
+}
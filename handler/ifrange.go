@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IfRangeHandler returns a handler that evaluates the If-Range header of range requests, according to
+// RFC 7233, section 3.2, against the validators produced by eTagFunc and lastModifiedFunc before calling next.
+// Either function may be nil. Entity-tags match only if they are strongly equal, and HTTP-dates match only if
+// they are exactly equal.
+//
+// If the If-Range header matches, it is removed from the request, so that next serves the requested range.
+// Otherwise, both the Range and If-Range headers are removed, so that next serves the full representation.
+// Requests without a Range header are passed to next unmodified.
+func IfRangeHandler(eTagFunc ETagFunc, lastModifiedFunc LastModifiedFunc, next http.Handler,
+	opts ...Option) http.Handler {
+
+	if next == nil {
+		panic(ErrNilHandler)
+	}
+
+	o := newOptions(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ir := r.Header.Get("If-Range")
+		if ir == "" || r.Header.Get("Range") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		if !matchIfRange(ir, eTagFunc, lastModifiedFunc, r, o) {
+			r.Header.Del("Range")
+		}
+		r.Header.Del("If-Range")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func matchIfRange(ir string, eTagFunc ETagFunc, lastModifiedFunc LastModifiedFunc, r *http.Request, o *options) bool {
+	ir = strings.TrimSpace(ir)
+
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		if eTagFunc == nil {
+			return false
+		}
+
+		irE, ok := o.parseETag(ir)
+		if !ok {
+			return false
+		}
+
+		e, ok := eTagFunc(nil, r)
+		return ok && e.equal(irE, false)
+	}
+
+	if lastModifiedFunc == nil {
+		return false
+	}
+
+	irT, err := http.ParseTime(ir)
+	if err != nil {
+		return false
+	}
+
+	lm, ok := lastModifiedFunc(nil, r)
+	return ok && lm.Truncate(time.Second).Equal(irT)
+}
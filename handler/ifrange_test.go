@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestIfRangeHandler(t *testing.T) {
+	modTime := time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		ifRange    string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "entity-tag match",
+			ifRange:    `"foo"`,
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "01234",
+		},
+		{
+			name:       "entity-tag mismatch",
+			ifRange:    `"bar"`,
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+		},
+		{
+			name:       "weak entity-tag",
+			ifRange:    `W/"foo"`,
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+		},
+		{
+			name:       "date match",
+			ifRange:    "Wed, 09 Jun 2021 10:18:15 GMT",
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "01234",
+		},
+		{
+			name:       "date mismatch",
+			ifRange:    "Wed, 09 Jun 2021 10:18:14 GMT",
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+		},
+		{
+			name:       "invalid date",
+			ifRange:    "yesterday",
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			// http.ServeContent honors Range headers, but would evaluate If-Range itself
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				is.Equal(r.Header.Get("If-Range"), "")
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+			})
+			h := IfRangeHandler(
+				func(w http.ResponseWriter, r *http.Request) (ETag, bool) {
+					return ETag{Tag: "foo"}, true
+				},
+				lastModifiedFunc(modTime.Add(500*time.Millisecond), true),
+				next)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Range", "bytes=0-4")
+			r.Header.Set("If-Range", test.ifRange)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Body.String(), test.wantBody)
+			is.Equal(r.Header.Get("If-Range"), test.ifRange) // original request not modified
+		})
+	}
+}
+
+func TestIfRangeHandler_NoRange(t *testing.T) {
+	is := is.New(t)
+
+	var ifRange string
+	h := IfRangeHandler(nil, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifRange = r.Header.Get("If-Range")
+	}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-Range", `"foo"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(ifRange, `"foo"`)
+}
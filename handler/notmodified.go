@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+)
+
+// headerWriter is an http.ResponseWriter that only provides headers, and discards anything else written to it.
+// It is used to evaluate conditional request headers against validators that are not part of a response.
+type headerWriter http.Header
+
+// Header implements http.ResponseWriter.
+func (w headerWriter) Header() http.Header {
+	return http.Header(w)
+}
+
+// Write implements http.ResponseWriter.
+func (w headerWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w headerWriter) WriteHeader(int) {}
+
+// RequestNotModified returns whether r's If-None-Match and If-Modified-Since headers match eTag and lastModified,
+// so that the response should be sent with the 304 Not Modified status code. Conditional request headers are
+// evaluated in the same way as by IfNoneMatchIfModifiedSinceHandler, using weak entity-tag comparison by default.
+// If eTag's Tag is empty, or if lastModified is the zero time, the respective validator is not used.
+//
+// RequestNotModified allows handlers to decide about the conditional outcome themselves, for example partway
+// through producing a response, and to abort using ForceNotModified.
+func RequestNotModified(r *http.Request, eTag ETag, lastModified time.Time, opts ...Option) bool {
+	o := newOptions(opts)
+
+	w := headerWriter{}
+	setPrecomputed(w, r, eTag, lastModified, o)
+
+	statusCode, _ := matchIfNoneMatchIfModifiedSince(w, r, o, o.weakComparison(r, true), http.StatusOK)
+	return statusCode == http.StatusNotModified
+}
+
+// ForceNotModified sends the response to w with the 304 Not Modified status code, without a body. If w is a
+// buffering response writer produced by this package, any body buffered so far is discarded, and the status code
+// configured using WithNotModifiedStatus is sent instead, if any. The downstream handler should not write anything
+// to w after calling ForceNotModified.
+//
+// If the response's header has already been sent, for example because buffering has been abandoned,
+// the status code cannot be changed anymore, and ForceNotModified returns false.
+func ForceNotModified(w http.ResponseWriter) bool {
+	ww := w
+	if bw, ok := w.(*BufferingWriter); ok {
+		ww = bw.rw
+	}
+
+	statusCode := http.StatusNotModified
+
+	if rw, ok := ww.(*responseWriter); ok {
+		if rw.headerWritten || rw.flushed {
+			return false
+		}
+		if rw.bodyBuf != nil {
+			rw.bodyBuf.Reset()
+		}
+		if rw.notModifiedStatus != 0 {
+			statusCode = rw.notModifiedStatus
+		}
+	}
+
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(statusCode)
+
+	return true
+}
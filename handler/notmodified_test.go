@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestRequestNotModified(t *testing.T) {
+	modTime := time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		reqHeaders   []string
+		eTag         ETag
+		lastModified time.Time
+		want         bool
+	}{
+		{
+			name:       "If-None-Match hit",
+			reqHeaders: []string{"If-None-Match", `"foo"`},
+			eTag:       ETag{Tag: "foo"},
+			want:       true,
+		},
+		{
+			name:       "If-None-Match weak hit",
+			reqHeaders: []string{"If-None-Match", `W/"foo"`},
+			eTag:       ETag{Tag: "foo"},
+			want:       true,
+		},
+		{
+			name:       "If-None-Match miss",
+			reqHeaders: []string{"If-None-Match", `"bar"`},
+			eTag:       ETag{Tag: "foo"},
+		},
+		{
+			name:         "If-Modified-Since hit",
+			reqHeaders:   []string{"If-Modified-Since", "Wed, 09 Jun 2021 10:18:15 GMT"},
+			lastModified: modTime,
+			want:         true,
+		},
+		{
+			name:         "If-Modified-Since miss",
+			reqHeaders:   []string{"If-Modified-Since", "Wed, 09 Jun 2021 10:18:14 GMT"},
+			lastModified: modTime,
+		},
+		{
+			name:         "If-None-Match takes precedence",
+			reqHeaders:   []string{"If-None-Match", `"bar"`, "If-Modified-Since", "Wed, 09 Jun 2021 10:18:15 GMT"},
+			eTag:         ETag{Tag: "foo"},
+			lastModified: modTime,
+		},
+		{
+			name:         "no conditional headers",
+			eTag:         ETag{Tag: "foo"},
+			lastModified: modTime,
+		},
+		{
+			name:       "no validators",
+			reqHeaders: []string{"If-None-Match", `"foo"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for i := 0; i < len(test.reqHeaders); i += 2 {
+				r.Header.Set(test.reqHeaders[i], test.reqHeaders[i+1])
+			}
+
+			is.Equal(RequestNotModified(r, test.eTag, test.lastModified), test.want)
+		})
+	}
+}
+
+func TestForceNotModified(t *testing.T) {
+	tests := []struct {
+		name   string
+		rm     ResponseMode
+		write  bool
+		wantOK bool
+	}{
+		{
+			name:   "after response",
+			rm:     AfterResponse,
+			write:  true,
+			wantOK: true,
+		},
+		{
+			name:   "after headers",
+			rm:     AfterHeaders,
+			wantOK: true,
+		},
+		{
+			name:   "after headers written",
+			rm:     AfterHeaders,
+			write:  true,
+			wantOK: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			ok := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"foo"`)
+				w.Header().Set("Content-Type", "text/plain")
+				if test.write {
+					_, _ = w.Write([]byte("partial"))
+				}
+				if RequestNotModified(r, ETag{Tag: "foo"}, time.Time{}) {
+					ok = ForceNotModified(w)
+				}
+			})
+			h := headerHandler(
+				func(w http.ResponseWriter, r *http.Request, statusCode int) int {
+					return statusCode
+				},
+				test.rm, next)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", `"foo"`)
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(ok, test.wantOK)
+			if test.wantOK {
+				is.Equal(w.Result().StatusCode, http.StatusNotModified)
+				is.Equal(w.Body.Len(), 0)
+				is.Equal(w.Result().Header.Get("Content-Type"), "")
+				is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+			}
+		})
+	}
+}
+
+func TestForceNotModified_ConditionalHandler(t *testing.T) {
+	is := is.New(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"foo"`)
+		_, _ = w.Write([]byte("partial"))
+		is.True(ForceNotModified(w))
+	})
+	h := ETagHandler(ETagFromBody(), AfterResponse, next, WithOnlyIfAbsent(true))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+	is.Equal(w.Body.Len(), 0)
+	is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+}
+
+func TestForceNotModified_NotModifiedStatus(t *testing.T) {
+	is := is.New(t)
+
+	const statusFresh = 399
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"foo"`)
+		_, _ = w.Write([]byte("partial"))
+		is.True(ForceNotModified(w))
+	})
+	h := ETagHandler(ETagFromBody(), AfterResponse, next, WithOnlyIfAbsent(true), WithNotModifiedStatus(statusFresh))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, statusFresh)
+	is.Equal(w.Body.Len(), 0)
+}
@@ -0,0 +1,1204 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option configures a handler or function returned by this package.
+// Options that do not apply to a particular handler or function are ignored by it.
+type Option func(*options)
+
+type options struct {
+	requestTrailers            bool
+	weakComparisonFunc         func(*http.Request) bool
+	eTagCache                  *ETagCache
+	cacheIdentityFunc          CacheIdentityFunc
+	fullResponseFuncs          []func(http.ResponseWriter, *http.Request)
+	fingerprintFunc            FingerprintFunc
+	requirePreconditionMethods []string
+	escapedQuotes              bool
+	bytesSavedFunc             func(int64, *http.Request)
+	immutable                  bool
+	eligibleStatusCodes        []int
+	strictRangeValidation      bool
+	errorFunc                  func(error, *http.Request)
+	failOnError                bool
+	maxBufferSize              int
+	lenientWeakPrefix          bool
+	weakMatchWarning           string
+	eTagHeaderName             string
+	lastModifiedHeaderName     string
+	contentTypeFilter          func(string) bool
+	serveReasonFunc            func(ServeReason, *http.Request)
+	validators                 []validator
+	debug304Body               bool
+	maxConditionHeaderBytes    int
+	hashExcludedHeaderNames    []string
+	notModifiedStatus          int
+	decodeContentEncoding      bool
+	eligibleMethods            []string
+	onlyIfAbsent               bool
+	contentDigest              bool
+	minuteGranularity          bool
+	bufferStatsFunc            func(bool, int, *http.Request)
+	eTagFormatter              func(string, []byte) ETag
+	eTagTimeout                time.Duration
+	ifModifiedSinceStore       *ETagCache
+	pathPrefixes               []string
+	strictStatusCheck          bool
+	initialBufferCapacity      int
+	eTagSuffixes               []string
+	stripContentLocation       bool
+}
+
+const defaultMaxConditionHeaderBytes = 64 * 1024
+
+type validator struct {
+	reqHeader  string
+	respHeader string
+	cmp        func(string, string) bool
+}
+
+// WithRequestTrailers configures whether request trailers should be consulted for conditional request headers
+// such as If-Match or If-None-Match, if the respective header is not present in the request's headers.
+//
+// Request trailers are only available after the request body has been read completely. Handlers returned by
+// this package evaluate conditional request headers when the downstream handler starts writing its response,
+// so the downstream handler must have read the request body in its entirety before writing any response data.
+// If the body has not been read completely at that point, trailers are not consulted. IfMatchHandler evaluates
+// the If-Match header before calling the downstream handler, so if the request declares it as a trailer, the request
+// body is read into memory first, and then passed to the downstream handler.
+//
+// The default is false.
+func WithRequestTrailers(b bool) Option {
+	return func(o *options) {
+		o.requestTrailers = b
+	}
+}
+
+// WithWeakComparisonFunc configures a function that determines per request whether entity-tags should be
+// compared weakly. This allows a single handler to use different comparison strengths depending on the
+// request's path or content type, for example. If f is nil, the comparison strength passed to the handler's
+// constructor is used.
+func WithWeakComparisonFunc(f func(*http.Request) bool) Option {
+	return func(o *options) {
+		o.weakComparisonFunc = f
+	}
+}
+
+// WithETagCache configures a cache used to memoize entity-tags computed from content hashes, so that unchanged
+// content does not need to be hashed again. For ETagFromBody, a CacheIdentityFunc must also be configured using
+// WithCacheIdentityFunc for the cache to be used. FileServer uses file paths, sizes, and last modification dates
+// as cache identities.
+func WithETagCache(c *ETagCache) Option {
+	return func(o *options) {
+		o.eTagCache = c
+	}
+}
+
+// WithCacheIdentityFunc configures a function that produces the cache identity of a response's content,
+// which is used to look up entity-tags in the cache configured using WithETagCache.
+func WithCacheIdentityFunc(f CacheIdentityFunc) Option {
+	return func(o *options) {
+		o.cacheIdentityFunc = f
+	}
+}
+
+// WithFullResponseFuncs configures functions that are called by IfNoneMatchIfModifiedSinceHandler after
+// conditional request headers have been evaluated, but only if the response is not a 304 Not Modified response.
+// The functions are called in order, and may set additional response headers, such as headers that are
+// expensive to produce.
+//
+// When handlers are chained, the header functions of inner handlers are called before those of outer handlers.
+// Because of that, chaining expensive header functions with a conditional handler wrapping them means that they
+// will be called even if the response ends up being a 304 Not Modified response. Using WithFullResponseFuncs
+// instead avoids calling them in that case. Note that headers that are required in 304 Not Modified responses,
+// such as Cache-Control, Expires, or Vary, should not be set using functions configured here.
+func WithFullResponseFuncs(funcs ...func(http.ResponseWriter, *http.Request)) Option {
+	return func(o *options) {
+		o.fullResponseFuncs = append(o.fullResponseFuncs, funcs...)
+	}
+}
+
+// WithFingerprint configures a function that produces a representation fingerprint, from which ETagHandler
+// builds a weak entity-tag. If f cannot produce a fingerprint, the ETag header will not be set.
+func WithFingerprint(f FingerprintFunc) Option {
+	return func(o *options) {
+		o.fingerprintFunc = f
+	}
+}
+
+// WithRequirePrecondition configures request methods for which IfMatchHandler requires requests to be conditional,
+// as specified by RFC 6585, section 3. Requests using any of those methods that contain neither an If-Match
+// nor an If-Unmodified-Since header are answered with the 428 Precondition Required status code.
+// This can be used to enforce optimistic concurrency control for unsafe methods such as PUT.
+//
+// Note that IfMatchHandler does not evaluate the If-Unmodified-Since header.
+//
+// By default, no request methods require preconditions.
+func WithRequirePrecondition(methods ...string) Option {
+	return func(o *options) {
+		o.requirePreconditionMethods = append(o.requirePreconditionMethods, methods...)
+	}
+}
+
+// WithEscapedQuotes configures whether backslash-escaped double-quotes in entity-tags should be supported.
+// If enabled, escaped double-quotes (\") in the opaque-tags of entity-tags parsed from request and response
+// headers are unescaped, and double-quotes in the Tag of entity-tags produced by ETagHandler are escaped.
+//
+// Note that RFC 7232 does not allow double-quotes in opaque-tags, escaped or otherwise. This option is provided
+// for interoperability with clients that produce such entity-tags regardless, and should be used with care.
+//
+// The default is false.
+func WithEscapedQuotes(b bool) Option {
+	return func(o *options) {
+		o.escapedQuotes = b
+	}
+}
+
+// WithBytesSaved configures a function that is called by IfNoneMatchIfModifiedSinceHandler whenever it produces
+// a 304 Not Modified response, with the size of the response body that would have been sent otherwise.
+// The size is determined by counting the bytes written by the downstream handler. If the downstream handler
+// does not write a body, such as for HEAD requests, the value of the Content-Length header set by the
+// downstream handler is used, if any.
+func WithBytesSaved(f func(n int64, r *http.Request)) Option {
+	return func(o *options) {
+		o.bytesSavedFunc = f
+	}
+}
+
+// WithImmutable configures ETagHandler and FileServer to set the Cache-Control header to
+// "max-age=31536000, immutable" in 200 OK responses that carry a strong entity-tag, replacing any existing
+// Cache-Control header. This signals caches that the response will never change, and need not be revalidated,
+// which is suitable for fingerprinted static assets, for example. This includes entity-tags set by the downstream
+// handler and kept due to WithOnlyIfAbsent. Responses with weak entity-tags or without entity-tags are not modified.
+func WithImmutable() Option {
+	return func(o *options) {
+		o.immutable = true
+	}
+}
+
+// WithEligibleStatusCodes configures additional response status codes for which IfNoneMatchIfModifiedSinceHandler
+// evaluates conditional request headers, in addition to 200 OK and 206 Partial Content. For example,
+// 301 Moved Permanently and 308 Permanent Redirect responses may carry validators, and can be revalidated
+// by caches if those status codes are made eligible. Similarly, stable error pages, such as 404 Not Found responses
+// produced using http.Error, may be made eligible, so that they can be revalidated. Responses with other status codes
+// are never modified.
+func WithEligibleStatusCodes(codes ...int) Option {
+	return func(o *options) {
+		o.eligibleStatusCodes = append(o.eligibleStatusCodes, codes...)
+	}
+}
+
+// WithEligibleMethods configures additional request methods for which IfNoneMatchIfModifiedSinceHandler sends
+// the 304 Not Modified status code, in addition to GET and HEAD. For example, the WebDAV methods REPORT and
+// PROPFIND, as well as OPTIONS, are safe, and may be made eligible. Methods should only be made eligible if they
+// are safe. Since PROPFIND responses are usually sent with the 207 Multi-Status status code, that status code
+// must be made eligible as well, using WithEligibleStatusCodes.
+//
+// According to RFC 7232, if the request's If-None-Match header matches, requests using other methods receive
+// the 412 Precondition Failed status code instead, and the request's If-Modified-Since header is not evaluated
+// for them. This is only done by PrecomputeHandler, ServeContent, and ServeWithValidators, which evaluate conditional
+// request headers before performing the request. IfNoneMatchIfModifiedSinceHandler and the other handlers evaluating
+// the downstream handler's response pass responses to such requests through unmodified instead, since the downstream
+// handler has already performed the request.
+func WithEligibleMethods(methods ...string) Option {
+	return func(o *options) {
+		o.eligibleMethods = append(o.eligibleMethods, methods...)
+	}
+}
+
+// WithStrictRangeValidation configures whether IfNoneMatchIfModifiedSinceHandler should refuse to produce a
+// 304 Not Modified response for Range requests if the response does not carry a strong entity-tag. Weak
+// entity-tags and last modification dates are not suitable for validating partial content, as specified by
+// RFC 7233, section 4.3. If enabled, such requests are answered with the full response produced by the
+// downstream handler instead.
+//
+// Note that the handler cannot turn a 206 Partial Content response produced by the downstream handler into
+// a full response. The downstream handler should itself refuse to serve ranges based on weak validators.
+// If enabled, ETagHandler removes weak entity-tags from 206 Partial Content responses, regardless of whether they
+// have been produced by its ETagFunc or set by the downstream handler, so that clients cannot combine the partial
+// content with other ranges based on a weak validator.
+//
+// The default is false.
+func WithStrictRangeValidation(b bool) Option {
+	return func(o *options) {
+		o.strictRangeValidation = b
+	}
+}
+
+// WithErrorFunc configures a function that is called with errors and diagnostic conditions encountered while
+// handling r. Errors reported to f do not necessarily cause the request to fail.
+func WithErrorFunc(f func(err error, r *http.Request)) Option {
+	return func(o *options) {
+		o.errorFunc = f
+	}
+}
+
+// WithFailOnError configures whether errors returned by functions adapted using ETagFuncFromE should cause
+// responses to be sent with the 500 Internal Server Error status code.
+//
+// The default is false, in which case errors are treated as if no entity-tag could be produced.
+func WithFailOnError(b bool) Option {
+	return func(o *options) {
+		o.failOnError = b
+	}
+}
+
+// WithMaxBufferSize configures the maximum number of bytes of a response body that ETagHandler buffers when
+// using the AfterResponse response mode. If the body produced by the downstream handler exceeds n bytes,
+// buffering is abandoned, and the body is streamed instead. In that case, the body is not available to
+// ETagFunc implementations, which receive the response before the body has been written completely.
+// ETagFromBody does not produce an entity-tag then, rather than producing one from incomplete data.
+//
+// The default is 0, which means no limit.
+func WithMaxBufferSize(n int) Option {
+	return func(o *options) {
+		o.maxBufferSize = n
+	}
+}
+
+// WithLenientWeakPrefix configures whether entity-tags parsed from request and response headers may use a
+// lower-case weak indicator ("w/") instead of the upper-case one ("W/") required by RFC 7232. This applies
+// to each member of an entity-tag list individually.
+//
+// The default is false.
+func WithLenientWeakPrefix(b bool) Option {
+	return func(o *options) {
+		o.lenientWeakPrefix = b
+	}
+}
+
+// WithWeakMatchWarning configures a Warning header value, such as `110 - "Response is Stale"`, that is added
+// to 304 Not Modified responses that resulted from a weak entity-tag comparison, that is, when either the
+// response's entity-tag or the matching entity-tag in the request's If-None-Match header is weak. Warning headers
+// are specified by RFC 7234, section 5.5. This can help debugging cache chains that rely on weak validators.
+//
+// The default is an empty value, in which case no Warning header is added.
+func WithWeakMatchWarning(warning string) Option {
+	return func(o *options) {
+		o.weakMatchWarning = warning
+	}
+}
+
+// WithETagHeaderName configures the name of the response header that handlers of this package read and write
+// entity-tags from and to, instead of ETag. This can be useful in layered caching architectures where validators
+// are carried in custom headers, such as X-Origin-ETag, before being normalized. FileServer and
+// ServeWithValidators always use the ETag header.
+func WithETagHeaderName(name string) Option {
+	return func(o *options) {
+		o.eTagHeaderName = name
+	}
+}
+
+// WithLastModifiedHeaderName configures the name of the response header that handlers of this package read and
+// write last modification dates from and to, instead of Last-Modified. FileServer and ServeWithValidators always
+// use the Last-Modified header.
+func WithLastModifiedHeaderName(name string) Option {
+	return func(o *options) {
+		o.lastModifiedHeaderName = name
+	}
+}
+
+// WithContentTypeFilter configures a function that determines, based on the value of a response's Content-Type
+// header, whether handlers of this package should process the response. If f returns false, the response is
+// passed through unmodified: the response body is not buffered, header functions are not called, and conditional
+// request headers are not evaluated. This can be used to exclude large binary content, for example.
+//
+// The filter only applies to the AfterHeaders and AfterResponse response modes. It is called with the
+// Content-Type header as set by the downstream handler when it starts writing its response, which may be
+// empty if the downstream handler relies on content type detection. When using the AfterResponse response mode,
+// the filter is called again for every write. If the downstream handler changes the Content-Type header while
+// writing its body so that the response becomes excluded, the body buffered so far is sent first, and the rest
+// of the body is passed through.
+func WithContentTypeFilter(f func(contentType string) bool) Option {
+	return func(o *options) {
+		o.contentTypeFilter = f
+	}
+}
+
+// WithServeReason configures a function that is called with the reason why a response has or has not been
+// served with the 304 Not Modified status code. This can be used to find out why caches are not effective,
+// for example. The function is called once per request, when the downstream handler starts writing its response.
+// It is not called for responses excluded from processing by the content type filter (see WithContentTypeFilter).
+//
+// This option only applies to IfNoneMatchIfModifiedSinceHandler, IfModifiedSinceHandler, and IfNoneMatchHandler.
+func WithServeReason(f func(reason ServeReason, r *http.Request)) Option {
+	return func(o *options) {
+		o.serveReasonFunc = f
+	}
+}
+
+// RegisterValidator registers a custom validator, such as CalDAV's Schedule-Tag, to be evaluated in addition to
+// entity-tags and last modification dates. Multiple validators may be registered by using this option multiple times.
+//
+// If a request contains the header reqHeader, and the response contains the header respHeader, cmp is called with
+// the values of both headers, in that order. If it returns true, the response is sent with the 304 Not Modified
+// status code. If the response does not contain the header respHeader, the full response is sent.
+//
+// Custom validators are evaluated after If-None-Match, and before If-Modified-Since. That is, they are only
+// evaluated if the request does not contain an If-None-Match header. If the request contains the header
+// of a custom validator, its If-Modified-Since header is not evaluated. If the request contains the headers of
+// multiple custom validators, only the first one registered is evaluated.
+//
+// This option only applies to IfNoneMatchIfModifiedSinceHandler.
+func RegisterValidator(reqHeader string, respHeader string, cmp func(a string, b string) bool) Option {
+	return func(o *options) {
+		o.validators = append(o.validators, validator{
+			reqHeader:  reqHeader,
+			respHeader: respHeader,
+			cmp:        cmp,
+		})
+	}
+}
+
+// WithDebug304Body configures whether responses sent with the 304 Not Modified status code should carry a short
+// diagnostic text listing the request's conditional request headers and the response's validators that were
+// compared, to see why a response was not modified. The same text is also set as the X-Debug-Not-Modified header,
+// since net/http's server refuses to send bodies with the 304 Not Modified status code, and discards the text.
+//
+// This option is meant for debugging during development only. Sending a body with the 304 Not Modified status code
+// is not compliant with RFC 7232, and may confuse clients and caches. It must not be used in production.
+//
+// The default is false.
+func WithDebug304Body(b bool) Option {
+	return func(o *options) {
+		o.debug304Body = b
+	}
+}
+
+// WithMaxConditionHeaderBytes configures the maximum length in bytes of If-None-Match and If-Match request headers
+// that are parsed. Longer headers are not parsed, to protect against clients forcing expensive parsing using huge
+// entity-tag lists, and ErrConditionHeaderTooLarge is passed to the function configured using WithErrorFunc, if any.
+// Such headers are treated in the same way as headers that cannot be parsed: If-None-Match does not match,
+// so that the full response is sent, and If-Match fails with the 412 Precondition Failed status code.
+//
+// The default is 64 KiB. If n is negative, the length is not limited.
+func WithMaxConditionHeaderBytes(n int) Option {
+	return func(o *options) {
+		o.maxConditionHeaderBytes = n
+	}
+}
+
+// WithHashExcludedHeaders configures the names of response headers that ETagFromResponse excludes from the hash
+// it produces entity-tags from. The names replace the default names.
+//
+// The default is Date and Set-Cookie.
+func WithHashExcludedHeaders(names ...string) Option {
+	return func(o *options) {
+		o.hashExcludedHeaderNames = append([]string{}, names...)
+	}
+}
+
+// WithNotModifiedStatus configures the status code to send instead of 304 Not Modified when a handler decides
+// that a response has not been modified. This is meant for unusual deployments only, such as gateways that
+// translate a custom status code into 304 Not Modified. The status code must be a 3xx status code, otherwise
+// 304 Not Modified is sent, and ErrInvalidNotModifiedStatus is passed to the function configured using WithErrorFunc,
+// if any.
+//
+// This option applies to IfNoneMatchIfModifiedSinceHandler, IfModifiedSinceHandler, IfNoneMatchHandler,
+// PrecomputeHandler, and ServeContent, as well as to ForceNotModified when called with a response writer of any
+// handler configured with this option. Responses that the downstream handler sends with the status code itself are
+// passed through unmodified, including their bodies. ServeWithValidators always sends 304 Not Modified.
+//
+// The default is 304 Not Modified.
+func WithNotModifiedStatus(code int) Option {
+	return func(o *options) {
+		o.notModifiedStatus = code
+	}
+}
+
+// WithDecodeContentEncoding configures whether ETagFromBody should decode response bodies according to the
+// response's Content-Encoding header before hashing them. This produces the same entity-tag regardless of whether
+// the body has been compressed using gzip or deflate, which allows placing ETagHandler either before or after
+// compression middleware. If the body uses any other content coding, no entity-tag is produced.
+//
+// Since different content codings of a representation must not share the same strong entity-tag, according
+// to RFC 7232, section 2.3.3, entity-tags produced from decoded bodies are always weak.
+//
+// The default is false.
+func WithDecodeContentEncoding(b bool) Option {
+	return func(o *options) {
+		o.decodeContentEncoding = b
+	}
+}
+
+// WithOnlyIfAbsent configures whether ETagHandler and LastModifiedHandler should only set the ETag or Last-Modified
+// header, respectively, if the response does not already contain one, such as when the downstream handler has set
+// its own header. In that case, the ETagFunc or LastModifiedFunc is not called at all. Since the downstream handler
+// has not produced any headers yet when using the BeforeHeaders response mode, this option should be used with
+// the other response modes.
+//
+// The default is false, which means that existing headers are replaced.
+func WithOnlyIfAbsent(b bool) Option {
+	return func(o *options) {
+		o.onlyIfAbsent = b
+	}
+}
+
+// WithContentDigest configures whether ETagFromBody should also set the Content-Digest header of the response,
+// according to RFC 9530, using the sha-256 algorithm. Where possible, the digest is produced from the same SHA-256
+// hash of the response body as the entity-tag, so the body is only hashed once. The digest is always produced from
+// the body as sent, even if WithDecodeContentEncoding is used. If the entity-tag is found in a cache configured
+// using WithETagCache, the body is still hashed to produce the digest.
+//
+// The default is false.
+func WithContentDigest(b bool) Option {
+	return func(o *options) {
+		o.contentDigest = b
+	}
+}
+
+// WithMinuteGranularity configures whether the If-Modified-Since header should be compared with the Last-Modified
+// header at the granularity of minutes instead of seconds, which means that dates that differ only in seconds are
+// considered equal. This is intended for interoperability with intermediaries that drop the seconds of dates.
+//
+// This is not compliant with RFC 7232, section 3.3, and may cause a response to be considered not modified even
+// though it has been modified within the same minute. It should only be used if such intermediaries cannot be fixed.
+//
+// The default is false, which means that dates are compared at the granularity of seconds.
+func WithMinuteGranularity(b bool) Option {
+	return func(o *options) {
+		o.minuteGranularity = b
+	}
+}
+
+// WithBufferStats configures a function that is called once the response has been completed, with whether
+// the response body has been buffered in its entirety, and the number of bytes buffered. This can be used to
+// find out how often and how much the AfterResponse response mode actually buffers, for example.
+//
+// If buffering has been abandoned because of WithMaxBufferSize or WithContentTypeFilter, buffered is false, and
+// size is the number of bytes buffered before buffering has been abandoned. For the other response modes,
+// buffered is always false, and size is always 0.
+func WithBufferStats(f func(buffered bool, size int, r *http.Request)) Option {
+	return func(o *options) {
+		o.bufferStatsFunc = f
+	}
+}
+
+// WithETagFormatter configures a function that turns a digest computed by this package into an entity-tag,
+// such as when hashing a response body using ETagFromBody. It is called with the name of the digest algorithm,
+// which is currently always "sha256", and the digest itself. This allows full control over the format of
+// entity-tags, such as base64 encoding or prefixing the algorithm name, as well as whether they are weak.
+// The formatter is used by ETagFromBody, ETagFromResponse, ServeContent, FileServer, and TemplateHandler.
+//
+// Entity-tags produced from decoded bodies (see WithDecodeContentEncoding) are always weak, regardless of
+// the formatter.
+//
+// The default is to produce strong entity-tags from the hex encoding of the digest, in the same way as StableETag.
+func WithETagFormatter(f func(algo string, sum []byte) ETag) Option {
+	return func(o *options) {
+		o.eTagFormatter = f
+	}
+}
+
+// WithETagTimeout configures a maximum duration for ETagFuncs to produce an entity-tag. If d is positive, ETagFuncs
+// are called with a request whose context is canceled after d, or when the original request's context is done,
+// whichever happens first. If the function does not return before that, no entity-tag is used, the context's
+// error is passed to the function configured using WithErrorFunc, and the response proceeds as if the function
+// had returned ok==false. This is useful for ETagFuncs that perform I/O, such as database lookups.
+//
+// Since the function cannot be interrupted, it keeps running in the background until it returns. It should
+// therefore honor the request's context. To keep the response safe from concurrent access, functions are passed
+// a detached copy of the response, containing its header, status code, and buffered body. Changes made to the
+// copy's header, such as the Content-Digest header set by ETagFromBody, are only applied to the response if
+// the function returns in time.
+//
+// The default is 0, which means that ETagFuncs are called directly, without a deadline.
+func WithETagTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.eTagTimeout = d
+	}
+}
+
+// WithIfModifiedSinceStore configures a store that is used to evaluate requests that contain an If-Modified-Since
+// header, but no If-None-Match header, using entity-tags instead of dates. The entity-tags of responses carrying
+// both the ETag and Last-Modified headers are recorded in store, keyed by the request URI and the last modification
+// date, and later requests carrying that date in their If-Modified-Since header are evaluated against the recorded
+// entity-tag. If no entity-tag has been recorded for the date, the dates are compared as usual. See the package
+// documentation for the trade-offs involved.
+//
+// The default is nil, which means that If-Modified-Since headers are always evaluated by comparing dates.
+func WithIfModifiedSinceStore(store *ETagCache) Option {
+	return func(o *options) {
+		o.ifModifiedSinceStore = store
+	}
+}
+
+// WithPathPrefixes configures the URL path prefixes of requests that handlers of this package should process,
+// such as "/static/". Requests for other paths are passed to the downstream handler directly: responses are not
+// buffered, header functions are not called, and conditional request headers are not evaluated. This avoids
+// overhead for excluded paths when a single handler is used for all requests.
+//
+// The default is to process requests for all paths.
+func WithPathPrefixes(prefixes ...string) Option {
+	return func(o *options) {
+		o.pathPrefixes = append(o.pathPrefixes, prefixes...)
+	}
+}
+
+// WithStrictStatusCheck configures whether handlers evaluating conditional request headers should check that
+// the status code sent by the downstream handler is expected for the request. If the downstream handler sends
+// the 206 Partial Content status code, but the request does not contain a Range header, ErrUnexpectedPartialContent
+// is passed to the function configured using WithErrorFunc. This can help find middleware that serves ranges
+// unexpectedly. The response itself is not modified.
+//
+// The default is false.
+func WithStrictStatusCheck(b bool) Option {
+	return func(o *options) {
+		o.strictStatusCheck = b
+	}
+}
+
+// WithInitialBufferCapacity configures the initial capacity of the buffer used to buffer response bodies
+// if the AfterResponse response mode is used, to avoid repeatedly growing the buffer for large bodies.
+// If the downstream handler sets the Content-Length header before writing the body, the buffer is sized
+// to that length instead, unless it exceeds the size configured using WithMaxBufferSize. Since the header may not
+// be accurate, the buffer is not pre-sized beyond 64 KiB or n, whichever is larger, and grows as the body is written.
+//
+// The default is 0, which means that the buffer starts out empty.
+func WithInitialBufferCapacity(n int) Option {
+	return func(o *options) {
+		o.initialBufferCapacity = n
+	}
+}
+
+// WithETagSuffixes configures suffixes that are ignored when comparing the entity-tags listed in the request's
+// If-None-Match header with the response's entity-tag. Some compression layers, such as Apache's mod_deflate,
+// append a suffix like "-gzip" to the entity-tag of compressed responses, and may also mark it as weak, so that
+// clients later send W/"abc-gzip" in the If-None-Match header for an original entity-tag of "abc". If "-gzip"
+// is configured as a suffix, both entity-tags are considered equal, so that such requests can still be answered
+// with the 304 Not Modified status code. Suffixes are removed from both the request's and the response's
+// entity-tags, so that comparisons also succeed if the response's entity-tag has already been suffixed.
+//
+// Since such compression layers usually produce weak entity-tags, weak entity-tag comparison must be used.
+//
+// The default is not to ignore any suffixes.
+func WithETagSuffixes(suffixes ...string) Option {
+	return func(o *options) {
+		o.eTagSuffixes = append(o.eTagSuffixes, suffixes...)
+	}
+}
+
+// WithNotModifiedContentLocation configures whether a Content-Location header set in the response is retained
+// if the response is sent with the 304 Not Modified status code. If content negotiation has selected a specific
+// representation, the Content-Location header identifies it, which helps clients associate the validators with
+// the right representation, as specified by RFC 7232, section 4.1. If disabled, the header is removed instead.
+//
+// The default is true.
+func WithNotModifiedContentLocation(b bool) Option {
+	return func(o *options) {
+		o.stripContentLocation = !b
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &o
+}
+
+func (o *options) weakComparison(r *http.Request, def bool) bool {
+	if o.weakComparisonFunc == nil {
+		return def
+	}
+	return o.weakComparisonFunc(r)
+}
+
+// eTag produces the entity-tag for w using f, or using the fingerprint function if f cannot produce one.
+// Entity-tags that do not survive a round trip through the ETag header are reported and discarded.
+func (o *options) eTag(f ETagFunc, w http.ResponseWriter, r *http.Request) (ETag, bool) {
+	e, ok := o.produceETag(f, w, r)
+	if !ok {
+		return ETag{}, false
+	}
+
+	if !o.roundTrips(e) {
+		o.reportError(ErrInvalidETag, r)
+		return ETag{}, false
+	}
+
+	return e, true
+}
+
+func (o *options) produceETag(f ETagFunc, w http.ResponseWriter, r *http.Request) (ETag, bool) {
+	if f != nil {
+		if e, ok := o.callETagFunc(f, w, r); ok {
+			return e, true
+		}
+	}
+
+	if o.fingerprintFunc == nil {
+		return ETag{}, false
+	}
+
+	fp, ok := o.fingerprintFunc(r)
+	if !ok {
+		return ETag{}, false
+	}
+
+	return fingerprintETag(fp), true
+}
+
+func (o *options) preconditionRequired(r *http.Request) bool {
+	for _, m := range o.requirePreconditionMethods {
+		if r.Method != m {
+			continue
+		}
+		return o.requestHeader(r, "If-Match") == "" && o.requestHeader(r, "If-Unmodified-Since") == ""
+	}
+	return false
+}
+
+func (o *options) normalizeWeakPrefix(s string) string {
+	if o.lenientWeakPrefix && strings.HasPrefix(s, "w/") {
+		return "W/" + s[2:]
+	}
+	return s
+}
+
+func (o *options) parseETag(s string) (ETag, bool) {
+	e, ok := eTagFromString(o.normalizeWeakPrefix(s))
+	if !ok {
+		return ETag{}, false
+	}
+
+	tag := e.Tag
+	if o.escapedQuotes {
+		tag = strings.ReplaceAll(tag, `\"`, "")
+	}
+
+	if strings.Contains(tag, `"`) {
+		return ETag{}, false
+	}
+
+	if o.escapedQuotes {
+		e.Tag = strings.ReplaceAll(e.Tag, `\"`, `"`)
+	}
+
+	return e, true
+}
+
+// callETagFunc calls f, giving up once the timeout configured in o has elapsed. If a timeout is configured, f is
+// called with a detached copy of w, so that it cannot access the response while it is being sent concurrently.
+// Changes made by f to the copy's header are applied to w only if f returns in time.
+func (o *options) callETagFunc(f ETagFunc, w http.ResponseWriter, r *http.Request) (ETag, bool) {
+	if o.eTagTimeout <= 0 {
+		return f(w, r)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), o.eTagTimeout)
+	defer cancel()
+
+	type result struct {
+		e  ETag
+		ok bool
+	}
+
+	dw := detachedWriter(w, r)
+	ch := make(chan result, 1)
+	go func() {
+		e, ok := f(dw, r.WithContext(ctx))
+		ch <- result{e, ok}
+	}()
+
+	select {
+	case res := <-ch:
+		attachWriter(w, dw)
+		return res.e, res.ok
+	case <-ctx.Done():
+		o.reportError(ctx.Err(), r)
+		return ETag{}, false
+	}
+}
+
+// detachedWriter returns a response writer that contains copies of w's header, status code, and buffered body,
+// but that does not share any state with w.
+func detachedWriter(w http.ResponseWriter, r *http.Request) *responseWriter {
+	var header http.Header
+	if w != nil {
+		header = w.Header().Clone()
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+
+	dw := &responseWriter{
+		w: headerWriter(header),
+		r: r,
+	}
+
+	if bw, ok := w.(*BufferingWriter); ok {
+		w = bw.rw
+	}
+	rw, ok := w.(*responseWriter)
+	if !ok {
+		return dw
+	}
+
+	dw.statusCode = rw.statusCode
+	dw.bufferBody = rw.bufferBody
+	dw.bufferAbandoned = rw.bufferAbandoned
+	if rw.bodyBuf != nil && !rw.bufferAbandoned {
+		dw.bodyBuf = bytes.NewBuffer(append([]byte(nil), rw.bodyBuf.Bytes()...))
+	}
+
+	return dw
+}
+
+// attachWriter applies the changes made to the header and state of dw, which has been returned by detachedWriter
+// for w, to w.
+func attachWriter(w http.ResponseWriter, dw *responseWriter) {
+	if w == nil {
+		return
+	}
+
+	header := w.Header()
+	detached := dw.Header()
+	for k := range header {
+		if _, ok := detached[k]; !ok {
+			delete(header, k)
+		}
+	}
+	for k, v := range detached {
+		header[k] = v
+	}
+
+	if bw, ok := w.(*BufferingWriter); ok {
+		w = bw.rw
+	}
+	if rw, ok := w.(*responseWriter); ok {
+		rw.bodyAccessed = rw.bodyAccessed || dw.bodyAccessed
+		rw.failed = rw.failed || dw.failed
+	}
+}
+
+// storedETag returns the entity-tag recorded for r's response w with the last modification date lm. If different
+// entity-tags have been recorded for lm, the returned entity-tag's Tag is empty.
+func (o *options) storedETag(w http.ResponseWriter, r *http.Request, lm time.Time) (ETag, bool) {
+	id, ok := storeIdentity(w, r, lm)
+	if !ok {
+		return ETag{}, false
+	}
+	return o.ifModifiedSinceStore.Get(id)
+}
+
+// storeValidators records the entity-tag of r's response w for its last modification date. If a different
+// entity-tag has already been recorded for that date, the date is marked as ambiguous by recording an empty
+// entity-tag instead.
+func (o *options) storeValidators(w http.ResponseWriter, r *http.Request) {
+	e, ok := o.responseETag(w)
+	if !ok {
+		return
+	}
+
+	lm, err := parseHTTPTime(w.Header().Get(o.lastModifiedHeader()))
+	if err != nil {
+		return
+	}
+
+	id, ok := storeIdentity(w, r, lm)
+	if !ok {
+		return
+	}
+
+	for {
+		prev, cached := o.ifModifiedSinceStore.Get(id)
+
+		next := e
+		if cached && prev != e {
+			next = ETag{}
+		}
+
+		// concurrent responses may have recorded a different entity-tag in the meantime
+		if o.ifModifiedSinceStore.CompareAndPut(id, prev, cached, next) {
+			return
+		}
+	}
+}
+
+// storeIdentity returns the identity under which the entity-tag of r's response w with the last modification
+// date lm is recorded. Entity-tags are recorded separately for each date, so that recording the entity-tag for
+// one date does not replace those recorded for others.
+func storeIdentity(w http.ResponseWriter, r *http.Request, lm time.Time) (CacheIdentity, bool) {
+	return varyIdentity(CacheIdentity{
+		Key:     r.URL.RequestURI() + "\x00" + strconv.FormatInt(lm.Unix(), 10),
+		ModTime: lm,
+	}, w.Header(), r)
+}
+
+// checkStatus reports statusCode if it is unexpected for r, and if configured in o.
+func (o *options) checkStatus(statusCode int, r *http.Request) {
+	if !o.strictStatusCheck || statusCode != http.StatusPartialContent || r.Header.Get("Range") != "" {
+		return
+	}
+	o.reportError(ErrUnexpectedPartialContent, r)
+}
+
+// matchAnySuffixed returns whether e matches any of eTags, ignoring the suffixes configured in o.
+func (o *options) matchAnySuffixed(e ETag, eTags []ETag, weakComparison bool) bool {
+	if len(o.eTagSuffixes) == 0 {
+		return e.matchAny(eTags, weakComparison)
+	}
+
+	trimmed := make([]ETag, len(eTags))
+	for i, e2 := range eTags {
+		trimmed[i] = o.trimETagSuffix(e2)
+	}
+	return o.trimETagSuffix(e).matchAny(trimmed, weakComparison)
+}
+
+// trimETagSuffix returns e with the first matching suffix configured in o removed from its tag.
+func (o *options) trimETagSuffix(e ETag) ETag {
+	for _, s := range o.eTagSuffixes {
+		if s != "" && strings.HasSuffix(e.Tag, s) {
+			e.Tag = strings.TrimSuffix(e.Tag, s)
+			return e
+		}
+	}
+	return e
+}
+
+// setContentLocation adjusts the Content-Location header of w, which is sent with the 304 Not Modified
+// status code, according to o.
+func (o *options) setContentLocation(w http.ResponseWriter) {
+	if o.stripContentLocation {
+		w.Header().Del("Content-Location")
+	}
+}
+
+// pathIncluded returns whether r should be processed according to the path prefixes configured in o.
+func (o *options) pathIncluded(r *http.Request) bool {
+	if len(o.pathPrefixes) == 0 {
+		return true
+	}
+
+	for _, p := range o.pathPrefixes {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// digestETag returns the entity-tag for the digest sum produced using the algorithm algo.
+func (o *options) digestETag(algo string, sum []byte) ETag {
+	if o.eTagFormatter != nil {
+		return o.eTagFormatter(algo, sum)
+	}
+
+	return ETag{
+		Tag: hex.EncodeToString(sum),
+	}
+}
+
+// roundTrips returns whether e is parsed back unchanged after formatting it for the ETag header, so that clients
+// can revalidate the response using it.
+func (o *options) roundTrips(e ETag) bool {
+	p, ok := o.parseETag(o.formatETag(e))
+	return ok && p.Tag == trimQuotes(e.Tag) && p.Weak == e.Weak
+}
+
+func (o *options) formatETag(e ETag) string {
+	if o.escapedQuotes {
+		e.Tag = strings.ReplaceAll(trimQuotes(e.Tag), `"`, `\"`)
+	}
+	return e.String()
+}
+
+func (o *options) reportBytesSaved(rw *responseWriter, r *http.Request) {
+	if o.bytesSavedFunc == nil || !rw.discardBody || !rw.notModified(rw.writtenStatusCode) {
+		return
+	}
+
+	n := rw.discardedBytes
+	if n == 0 && rw.contentLength > 0 {
+		n = rw.contentLength
+	}
+
+	o.bytesSavedFunc(n, r)
+}
+
+// reportBufferStats reports the buffering statistics of rw, which may be nil if the response has not been wrapped.
+func (o *options) reportBufferStats(rw *responseWriter, r *http.Request) {
+	if o.bufferStatsFunc == nil {
+		return
+	}
+
+	if rw == nil {
+		o.bufferStatsFunc(false, 0, r)
+		return
+	}
+
+	o.bufferStatsFunc(rw.bodyBuf != nil && !rw.bufferAbandoned, rw.bufferedSize, r)
+}
+
+func (o *options) reportServeReason(reason ServeReason, r *http.Request) {
+	if o.serveReasonFunc == nil {
+		return
+	}
+	o.serveReasonFunc(reason, r)
+}
+
+func (o *options) setDebug304Body(w http.ResponseWriter, r *http.Request) {
+	if !o.debug304Body {
+		return
+	}
+
+	text := "304 Not Modified:"
+	for _, h := range [][2]string{
+		{"If-None-Match", o.requestHeader(r, "If-None-Match")},
+		{o.eTagHeader(), w.Header().Get(o.eTagHeader())},
+		{"If-Modified-Since", o.requestHeader(r, "If-Modified-Since")},
+		{o.lastModifiedHeader(), w.Header().Get(o.lastModifiedHeader())},
+	} {
+		if h[1] != "" {
+			text += " " + h[0] + ": " + h[1] + ";"
+		}
+	}
+	text = strings.TrimSuffix(text, ";")
+
+	w.Header().Set("X-Debug-Not-Modified", text)
+	if rw, ok := w.(*responseWriter); ok {
+		rw.debugBody = text + "\n"
+	}
+}
+
+func (o *options) writeDebug304Body(rw *responseWriter) {
+	if rw.debugBody == "" || !rw.notModified(rw.writtenStatusCode) {
+		return
+	}
+	_, _ = io.WriteString(rw.w, rw.debugBody)
+}
+
+// reportTrailerETag reports ErrETagInTrailer if r contains an If-None-Match header that could not be evaluated
+// because rw's ETag has only been set as a trailer.
+func (o *options) reportTrailerETag(rw *responseWriter, r *http.Request) {
+	if o.errorFunc == nil || o.requestHeader(r, "If-None-Match") == "" {
+		return
+	}
+
+	d, ok := DecisionFromContext(r.Context())
+	if !ok || d.Reason != ServeReasonNoValidator {
+		return
+	}
+
+	h := rw.Header()
+	name := o.eTagHeader()
+	if h.Get(http.TrailerPrefix+name) != "" || (declaredTrailer(h, name) && h.Get(name) != "") {
+		o.reportError(ErrETagInTrailer, r)
+	}
+}
+
+func (o *options) setImmutable(w http.ResponseWriter, statusCode int) {
+	if !o.immutable || statusCode != http.StatusOK {
+		return
+	}
+
+	e, ok := o.responseETag(w)
+	if !ok || e.Weak {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "max-age=31536000, immutable")
+}
+
+// evaluable returns whether conditional request headers should be evaluated for the response w with statusCode,
+// and if not, the reason why.
+func (o *options) evaluable(w http.ResponseWriter, r *http.Request, statusCode int) (ServeReason, bool) {
+	switch {
+	case !o.eligibleStatusCode(statusCode):
+		return ServeReasonIneligibleStatus, false
+	case o.rejectWeakRange(w, r):
+		return ServeReasonWeakRange, false
+	default:
+		return 0, true
+	}
+}
+
+func (o *options) eligibleStatusCode(statusCode int) bool {
+	if statusCode == http.StatusOK || statusCode == http.StatusPartialContent {
+		return true
+	}
+
+	for _, c := range o.eligibleStatusCodes {
+		if c == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *options) eligibleMethod(method string) bool {
+	if method == http.MethodGet || method == http.MethodHead {
+		return true
+	}
+
+	for _, m := range o.eligibleMethods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *options) rejectWeakRange(w http.ResponseWriter, r *http.Request) bool {
+	if !o.strictRangeValidation || r.Header.Get("Range") == "" {
+		return false
+	}
+
+	e, ok := o.responseETag(w)
+	return !ok || e.Weak
+}
+
+// removeWeakPartialETag removes the ETag header from the response w with statusCode if it carries a weak entity-tag
+// and the response contains partial content, as configured in o.
+func (o *options) removeWeakPartialETag(w http.ResponseWriter, statusCode int) {
+	if !o.strictRangeValidation || statusCode != http.StatusPartialContent {
+		return
+	}
+
+	if e, ok := o.responseETag(w); ok && e.Weak {
+		w.Header().Del(o.eTagHeader())
+	}
+}
+
+func (o *options) setWeakMatchWarning(w http.ResponseWriter, e ETag, inmEs []ETag) {
+	if o.weakMatchWarning == "" || e.matchAny(inmEs, false) {
+		return
+	}
+	w.Header().Add("Warning", o.weakMatchWarning)
+}
+
+func (o *options) hashExcludedHeaders() []string {
+	if o.hashExcludedHeaderNames == nil {
+		return []string{"Date", "Set-Cookie"}
+	}
+	return o.hashExcludedHeaderNames
+}
+
+func (o *options) eTagHeader() string {
+	if o.eTagHeaderName == "" {
+		return "ETag"
+	}
+	return o.eTagHeaderName
+}
+
+// responseETagValue returns the value of the response's ETag header. OWS is trimmed in the same way as for request
+// headers, in case the downstream handler has set it by mistake.
+func (o *options) responseETagValue(w http.ResponseWriter) string {
+	return strings.TrimSpace(w.Header().Get(o.eTagHeader()))
+}
+
+// responseETag returns the entity-tag of the response's ETag header.
+func (o *options) responseETag(w http.ResponseWriter) (ETag, bool) {
+	return o.parseETag(o.responseETagValue(w))
+}
+
+func (o *options) lastModifiedHeader() string {
+	if o.lastModifiedHeaderName == "" {
+		return "Last-Modified"
+	}
+	return o.lastModifiedHeaderName
+}
+
+// notModifiedStatusCode returns the status code to send for responses to r that have not been modified,
+// reporting ErrInvalidNotModifiedStatus if the configured status code is invalid.
+func (o *options) notModifiedStatusCode(r *http.Request) int {
+	switch {
+	case o.notModifiedStatus == 0:
+		return http.StatusNotModified
+	case o.customNotModifiedStatus() == 0:
+		o.reportError(ErrInvalidNotModifiedStatus, r)
+		return http.StatusNotModified
+	default:
+		return o.notModifiedStatus
+	}
+}
+
+// customNotModifiedStatus returns the configured status code to send instead of 304 Not Modified,
+// or 0 if none or an invalid one is configured.
+func (o *options) customNotModifiedStatus() int {
+	if o.notModifiedStatus < 300 || o.notModifiedStatus > 399 {
+		return 0
+	}
+	return o.notModifiedStatus
+}
+
+func (o *options) reportError(err error, r *http.Request) {
+	if o.errorFunc == nil {
+		return
+	}
+	o.errorFunc(err, r)
+}
+
+// conditionHeaderTooLarge returns whether the conditional request header value s exceeds the configured
+// maximum length, reporting ErrConditionHeaderTooLarge if so.
+func (o *options) conditionHeaderTooLarge(s string, r *http.Request) bool {
+	n := o.maxConditionHeaderBytes
+	if n == 0 {
+		n = defaultMaxConditionHeaderBytes
+	}
+
+	if n < 0 || len(s) <= n {
+		return false
+	}
+
+	o.reportError(ErrConditionHeaderTooLarge, r)
+	return true
+}
+
+// requestHeaderList works like requestHeader, but for headers containing comma-separated lists. If the request
+// contains multiple header lines, they are combined into a single list, as specified by RFC 7230, section 3.2.2.
+func (o *options) requestHeaderList(r *http.Request, name string) string {
+	values := r.Header.Values(name)
+	if len(values) > 1 {
+		return strings.Join(values, ", ")
+	}
+	return o.requestHeader(r, name)
+}
+
+// readRequestTrailers reads r's body into memory if request trailers are to be consulted, and r declares the header
+// name as a trailer without containing it as a header. Trailers are only available once the body has been read.
+// It returns a shallow copy of r whose body contains the data read.
+func (o *options) readRequestTrailers(r *http.Request, name string) (*http.Request, error) {
+	if !o.requestTrailers || r.Header.Get(name) != "" || r.Body == nil {
+		return r, nil
+	}
+	if _, declared := r.Trailer[http.CanonicalHeaderKey(name)]; !declared {
+		return r, nil
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return r, err
+	}
+
+	r2 := r.Clone(r.Context())
+	r2.Body = io.NopCloser(bytes.NewReader(b))
+	return r2, nil
+}
+
+func (o *options) requestHeader(r *http.Request, name string) string {
+	if v := r.Header.Get(name); v != "" {
+		return v
+	}
+
+	if !o.requestTrailers || r.Trailer == nil {
+		return ""
+	}
+
+	return r.Trailer.Get(name)
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+)
+
+// PrecomputeFunc produces the validators of r's response cheaply, without producing the response itself, for example
+// by looking them up in a database. If eTag's Tag is empty, or if lastModified is the zero time, the respective
+// validator is not used. The function returns PrecomputeAvailable if it has produced validators, and one of the other
+// PrecomputeResult values otherwise.
+type PrecomputeFunc func(r *http.Request) (eTag ETag, lastModified time.Time, result PrecomputeResult)
+
+// PrecomputeResult is the result of a PrecomputeFunc.
+type PrecomputeResult int
+
+const (
+	// PrecomputeUnavailable indicates that validators could not be produced. The request is passed to
+	// the downstream handler without evaluating any conditional request headers.
+	PrecomputeUnavailable PrecomputeResult = iota
+
+	// PrecomputeAvailable indicates that validators have been produced.
+	PrecomputeAvailable
+
+	// PrecomputeNotFound indicates that the resource does not exist. The 404 Not Found status code is sent,
+	// without calling the downstream handler.
+	PrecomputeNotFound
+
+	// PrecomputeGone indicates that the resource does not exist anymore, and that this condition is likely
+	// to be permanent. The 410 Gone status code is sent, without calling the downstream handler.
+	PrecomputeGone
+)
+
+// PrecomputeHandler returns a handler that calls f once per request to produce the validators of the response
+// before calling next. If f produces validators, they are set as the ETag and Last-Modified headers of the response,
+// and the request's If-None-Match and If-Modified-Since headers are evaluated against them, in the same way as
+// by IfNoneMatchIfModifiedSinceHandler, using weak entity-tag comparison by default. If they match, the response is
+// sent with the 304 Not Modified status code, or the one configured using WithNotModifiedStatus, without calling
+// next at all. Otherwise, next is called to produce
+// the full response.
+//
+// If the request contains an If-Match header, it is evaluated against the entity-tag produced by f before
+// the If-None-Match header, in the same way as by IfMatchHandler. If it does not match, the response is sent
+// with the 412 Precondition Failed status code, without calling next. This allows even safe requests such as GET
+// to be made conditional on the current entity-tag, without producing the response first.
+//
+// If f cannot produce validators, next is called without evaluating any conditional request headers.
+// If f reports that the resource does not exist (anymore), the 404 Not Found or 410 Gone status code is sent
+// instead, without calling next, so that a 304 Not Modified response is never sent for a deleted resource,
+// even if the request's conditional request headers still reference it.
+//
+// PrecomputeHandler is the fastest way to answer conditional requests, and should be preferred over the other
+// handlers of this package if validators can be produced without producing the response.
+func PrecomputeHandler(f PrecomputeFunc, next http.Handler, opts ...Option) http.Handler {
+	if next == nil {
+		panic(ErrNilHandler)
+	}
+
+	o := newOptions(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !o.pathIncluded(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		e, lm, res := f(r)
+		if res != PrecomputeAvailable {
+			serveUnavailable(w, r, res, next)
+			return
+		}
+
+		setPrecomputed(w, r, e, lm, o)
+
+		if tryMatchIfMatch(w, r, o, http.StatusOK) == http.StatusPreconditionFailed {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		statusCode, reason := matchIfNoneMatchIfModifiedSince(w, r, o, o.weakComparison(r, true), http.StatusOK)
+		o.reportServeReason(reason, r)
+
+		if statusCode != http.StatusOK {
+			if statusCode == http.StatusNotModified {
+				o.setContentLocation(w)
+				statusCode = o.notModifiedStatusCode(r)
+			}
+			w.WriteHeader(statusCode)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setPrecomputed sets the validators produced by a PrecomputeFunc as the response's headers.
+func setPrecomputed(w http.ResponseWriter, r *http.Request, e ETag, lm time.Time, o *options) {
+	if e.Tag != "" && !o.roundTrips(e) {
+		o.reportError(ErrInvalidETag, r)
+		e = ETag{}
+	}
+	if e.Tag != "" {
+		w.Header().Set(o.eTagHeader(), o.formatETag(e))
+	}
+	if !lm.IsZero() {
+		w.Header().Set(o.lastModifiedHeader(), lm.UTC().Format(http.TimeFormat))
+	}
+}
+
+// serveUnavailable serves r if validators are not available according to res.
+func serveUnavailable(w http.ResponseWriter, r *http.Request, res PrecomputeResult, next http.Handler) {
+	switch res {
+	case PrecomputeNotFound:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case PrecomputeGone:
+		http.Error(w, http.StatusText(http.StatusGone), http.StatusGone)
+	default:
+		next.ServeHTTP(w, r)
+	}
+}
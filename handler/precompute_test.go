@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestPrecomputeHandler(t *testing.T) {
+	modTime := time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		reqHeaders []string
+		wantStatus int
+		wantNext   bool
+		wantBody   string
+	}{
+		{
+			name:       "If-None-Match hit",
+			reqHeaders: []string{"If-None-Match", `"foo"`},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "If-Modified-Since hit",
+			reqHeaders: []string{"If-Modified-Since", "Wed, 09 Jun 2021 10:18:15 GMT"},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "miss",
+			reqHeaders: []string{"If-None-Match", `"bar"`},
+			wantStatus: http.StatusOK,
+			wantNext:   true,
+			wantBody:   "body",
+		},
+		{
+			name:       "no conditional headers",
+			wantStatus: http.StatusOK,
+			wantNext:   true,
+			wantBody:   "body",
+		},
+		{
+			name:       "If-Match hit",
+			reqHeaders: []string{"If-Match", `"foo"`},
+			wantStatus: http.StatusOK,
+			wantNext:   true,
+			wantBody:   "body",
+		},
+		{
+			name:       "If-Match miss",
+			reqHeaders: []string{"If-Match", `"bar"`},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "If-Match miss If-None-Match hit",
+			reqHeaders: []string{"If-Match", `"bar"`, "If-None-Match", `"foo"`},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			calls := 0
+			nextCalled := false
+			h := PrecomputeHandler(
+				func(r *http.Request) (ETag, time.Time, PrecomputeResult) {
+					calls++
+					return ETag{Tag: "foo"}, modTime, PrecomputeAvailable
+				},
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					nextCalled = true
+					_, _ = w.Write([]byte("body"))
+				}))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for i := 0; i < len(test.reqHeaders); i += 2 {
+				r.Header.Set(test.reqHeaders[i], test.reqHeaders[i+1])
+			}
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(calls, 1)
+			is.Equal(nextCalled, test.wantNext)
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Body.String(), test.wantBody)
+			is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+			is.Equal(w.Result().Header.Get("Last-Modified"), "Wed, 09 Jun 2021 10:18:15 GMT")
+		})
+	}
+}
+
+func TestPrecomputeHandler_NotOK(t *testing.T) {
+	is := is.New(t)
+
+	h := PrecomputeHandler(
+		func(r *http.Request) (ETag, time.Time, PrecomputeResult) {
+			return ETag{}, time.Time{}, PrecomputeUnavailable
+		},
+		contentHandler([]byte("body")))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"foo"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Body.String(), "body")
+	is.Equal(w.Result().Header.Get("ETag"), "")
+}
+
+func TestPrecomputeHandler_Missing(t *testing.T) {
+	tests := []struct {
+		name       string
+		result     PrecomputeResult
+		wantStatus int
+	}{
+		{
+			name:       "not found",
+			result:     PrecomputeNotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "gone",
+			result:     PrecomputeGone,
+			wantStatus: http.StatusGone,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			nextCalled := false
+			h := PrecomputeHandler(
+				func(r *http.Request) (ETag, time.Time, PrecomputeResult) {
+					return ETag{}, time.Time{}, test.result
+				},
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					nextCalled = true
+				}))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", `"foo"`)
+			r.Header.Set("If-Modified-Since", "Wed, 09 Jun 2021 10:18:15 GMT")
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.True(!nextCalled)
+			is.Equal(w.Result().Header.Get("ETag"), "")
+		})
+	}
+}
+
+func TestPrecomputeHandler_NotModifiedStatus(t *testing.T) {
+	is := is.New(t)
+
+	const statusFresh = 399
+
+	h := PrecomputeHandler(
+		func(r *http.Request) (ETag, time.Time, PrecomputeResult) {
+			return ETag{Tag: "foo"}, time.Time{}, PrecomputeAvailable
+		},
+		contentHandler([]byte("body")),
+		WithNotModifiedStatus(statusFresh))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"foo"`)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, statusFresh)
+	is.Equal(w.Body.Len(), 0)
+}
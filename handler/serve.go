@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ServeWithValidators replies to r with body, setting the ETag and Last-Modified headers in the response to
+// eTag and modTime, respectively. If eTag's Tag is empty, the ETag header is not set. If modTime is the zero
+// time, the Last-Modified header is not set.
+//
+// Conditional request headers are evaluated in the same way as by IfNoneMatchIfModifiedSinceHandler, using weak
+// entity-tag comparison if weakETagComparison==true. If they match, the 304 Not Modified status code is sent
+// without a body, or the 412 Precondition Failed status code if the request method is neither GET nor HEAD.
+// Otherwise, body is sent using the 200 OK status code.
+//
+// ServeWithValidators is similar to http.ServeContent, but is limited to in-memory bodies and does not support
+// Range requests.
+func ServeWithValidators(w http.ResponseWriter, r *http.Request, body []byte, eTag ETag, modTime time.Time,
+	weakETagComparison bool) {
+
+	serveWithValidators(w, r, body, eTag, modTime, weakETagComparison, &options{})
+}
+
+// serveWithValidators works like ServeWithValidators, but sets and evaluates validators according to o.
+func serveWithValidators(w http.ResponseWriter, r *http.Request, body []byte, eTag ETag, modTime time.Time,
+	weakETagComparison bool, o *options) {
+
+	if eTag.Tag != "" {
+		w.Header().Set(o.eTagHeader(), o.formatETag(eTag))
+	}
+	if !modTime.IsZero() {
+		w.Header().Set(o.lastModifiedHeader(), modTime.UTC().Format(http.TimeFormat))
+	}
+
+	statusCode, _ := matchIfNoneMatchIfModifiedSince(w, r, o, weakETagComparison, http.StatusOK)
+	if statusCode != http.StatusOK {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(statusCode)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	_, _ = w.Write(body)
+}
+
+// ServeContent replies to r using the content from content, in the same way as http.ServeContent, including support
+// for Range and If-Range requests. Unlike http.ServeContent, it evaluates the If-Match, If-None-Match, and
+// If-Modified-Since request headers in the same way as the handlers of this package, so that options such as
+// WithWeakComparisonFunc, WithLenientWeakPrefix, WithEscapedQuotes, or WithNotModifiedStatus apply. Weak entity-tag
+// comparison is used for If-None-Match by default.
+//
+// If the response does not contain an ETag header yet, ServeContent produces an entity-tag: if a fingerprint
+// function is configured using WithFingerprint, a weak entity-tag is produced from the fingerprint. Otherwise,
+// a strong entity-tag is produced from a SHA-256 hash of the content, in the same way as StableETag. If an ETagCache
+// is configured using WithETagCache, hashes are cached using an identity made up of r's URL path, the content's
+// size, and modtime.
+//
+// If modtime is neither the zero time nor the Unix epoch, the Last-Modified header is set to modtime.
+func ServeContent(w http.ResponseWriter, r *http.Request, name string, modtime time.Time, content io.ReadSeeker,
+	opts ...Option) {
+
+	o := newOptions(opts)
+
+	if w.Header().Get(o.eTagHeader()) == "" {
+		e, err := contentETag(w, r, modtime, content, o)
+		if err != nil {
+			o.reportError(err, r)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(o.eTagHeader(), o.formatETag(e))
+	}
+
+	if !modtime.IsZero() && !modtime.Equal(unixEpoch) {
+		w.Header().Set(o.lastModifiedHeader(), modtime.UTC().Format(http.TimeFormat))
+	}
+
+	statusCode := tryMatchIfMatch(w, r, o, http.StatusOK)
+	if statusCode == http.StatusOK {
+		statusCode, _ = matchIfNoneMatchIfModifiedSince(w, r, o, o.weakComparison(r, true), statusCode)
+	}
+
+	if statusCode != http.StatusOK {
+		if statusCode == http.StatusNotModified {
+			statusCode = o.notModifiedStatusCode(r)
+		}
+
+		h := w.Header()
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	// the conditional request headers have been evaluated already, and must not be evaluated again
+	r = r.Clone(r.Context())
+	r.Header.Del("If-Match")
+	r.Header.Del("If-None-Match")
+	r.Header.Del("If-Modified-Since")
+
+	http.ServeContent(w, r, name, modtime, content)
+}
+
+// contentETag returns the entity-tag for content, according to o.
+func contentETag(w http.ResponseWriter, r *http.Request, modtime time.Time, content io.ReadSeeker,
+	o *options) (ETag, error) {
+
+	if o.fingerprintFunc != nil {
+		if fp, ok := o.fingerprintFunc(r); ok {
+			return fingerprintETag(fp), nil
+		}
+	}
+
+	return hashContentETag(w, r, modtime, content, o)
+}
+
+// hashContentETag returns the entity-tag for content produced from a hash of content, looking it up in
+// the ETagCache configured in o first, if any.
+func hashContentETag(w http.ResponseWriter, r *http.Request, modtime time.Time, content io.ReadSeeker,
+	o *options) (ETag, error) {
+
+	id, cacheable, err := contentIdentity(w, r, modtime, content)
+	if err != nil {
+		return ETag{}, err
+	}
+	cacheable = cacheable && o.eTagCache != nil
+
+	if cacheable {
+		if e, ok := o.eTagCache.Get(id); ok {
+			return e, nil
+		}
+	}
+
+	e, err := readerETag(content, o)
+	if err != nil {
+		return ETag{}, err
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return ETag{}, err
+	}
+
+	if cacheable {
+		o.eTagCache.Put(id, e)
+	}
+
+	return e, nil
+}
+
+// contentIdentity returns the identity to cache content's entity-tag with, and whether it can be cached at all.
+func contentIdentity(w http.ResponseWriter, r *http.Request, modtime time.Time,
+	content io.ReadSeeker) (CacheIdentity, bool, error) {
+
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return CacheIdentity{}, false, err
+	}
+
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return CacheIdentity{}, false, err
+	}
+
+	id, cacheable := varyIdentity(CacheIdentity{
+		Key:     r.URL.Path,
+		Size:    size,
+		ModTime: modtime,
+	}, w.Header(), r)
+
+	return id, cacheable, nil
+}
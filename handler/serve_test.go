@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestServeWithValidators(t *testing.T) {
+	is := is.New(t)
+
+	body := []byte("body")
+	modTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	ServeWithValidators(w, r, body, ETag{Tag: "foo"}, modTime, false)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+	is.Equal(w.Result().Header.Get("Last-Modified"), "Sat, 02 Jan 2021 03:04:05 GMT")
+	is.Equal(w.Result().Header.Get("Content-Length"), "4")
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(b, body)
+}
+
+func TestServeWithValidators_NotModified(t *testing.T) {
+	tests := []struct {
+		name       string
+		headerName string
+		value      string
+	}{
+		{
+			name:       "If-None-Match",
+			headerName: "If-None-Match",
+			value:      `"foo"`,
+		},
+		{
+			name:       "If-Modified-Since",
+			headerName: "If-Modified-Since",
+			value:      "Sat, 02 Jan 2021 03:04:05 GMT",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			modTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(test.headerName, test.value)
+
+			ServeWithValidators(w, r, []byte("body"), ETag{Tag: "foo"}, modTime, false)
+
+			is.Equal(w.Result().StatusCode, http.StatusNotModified)
+			is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+			is.Equal(w.Body.Len(), 0)
+		})
+	}
+}
+
+func TestServeWithValidators_NoValidators(t *testing.T) {
+	is := is.New(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"foo"`)
+
+	ServeWithValidators(w, r, []byte("body"), ETag{}, time.Time{}, false)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), "")
+	is.Equal(w.Result().Header.Get("Last-Modified"), "")
+}
+
+func TestServeContent_Parity(t *testing.T) {
+	content := "0123456789"
+	eTag := StableETag([]byte(content)).String()
+	modTime := time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		method     string
+		reqHeaders []string
+		wantStatus int
+	}{
+		{
+			name:       "no headers",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "If-None-Match match",
+			reqHeaders: []string{"If-None-Match", eTag},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "If-None-Match mismatch",
+			reqHeaders: []string{"If-None-Match", `"foo"`},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "If-Modified-Since equal",
+			reqHeaders: []string{"If-Modified-Since", "Wed, 09 Jun 2021 10:18:15 GMT"},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "If-Modified-Since earlier",
+			reqHeaders: []string{"If-Modified-Since", "Tue, 08 Jun 2021 10:18:15 GMT"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "If-Match match",
+			reqHeaders: []string{"If-Match", eTag},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "If-Match mismatch",
+			reqHeaders: []string{"If-Match", `"foo"`},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "Range",
+			reqHeaders: []string{"Range", "bytes=0-4"},
+			wantStatus: http.StatusPartialContent,
+		},
+		{
+			name:       "If-Range match",
+			reqHeaders: []string{"Range", "bytes=0-4", "If-Range", eTag},
+			wantStatus: http.StatusPartialContent,
+		},
+		{
+			name:       "If-Range mismatch",
+			reqHeaders: []string{"Range", "bytes=0-4", "If-Range", `"foo"`},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "HEAD",
+			method:     http.MethodHead,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			newRequest := func() *http.Request {
+				r := httptest.NewRequest(method, "/file.txt", nil)
+				for i := 0; i < len(test.reqHeaders); i += 2 {
+					r.Header.Set(test.reqHeaders[i], test.reqHeaders[i+1])
+				}
+				return r
+			}
+
+			w := httptest.NewRecorder()
+			ServeContent(w, newRequest(), "file.txt", modTime, strings.NewReader(content))
+
+			stdW := httptest.NewRecorder()
+			stdW.Header().Set("ETag", eTag)
+			http.ServeContent(stdW, newRequest(), "file.txt", modTime, strings.NewReader(content))
+
+			is.Equal(w.Result().StatusCode, test.wantStatus)
+			is.Equal(w.Result().StatusCode, stdW.Result().StatusCode)
+			is.Equal(w.Result().Header.Get("ETag"), eTag)
+			is.Equal(w.Body.String(), stdW.Body.String())
+		})
+	}
+}
+
+func TestServeContent_WeakComparisonFunc(t *testing.T) {
+	is := is.New(t)
+
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `W/"foo"`)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `W/"foo"`)
+
+	ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader("content"),
+		WithWeakComparisonFunc(func(r *http.Request) bool {
+			return false
+		}))
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Body.String(), "content")
+}
+
+func TestServeContent_NotModifiedStatus(t *testing.T) {
+	is := is.New(t)
+
+	const statusFresh = 399
+
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"foo"`)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"foo"`)
+
+	ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader("content"), WithNotModifiedStatus(statusFresh))
+
+	is.Equal(w.Result().StatusCode, statusFresh)
+	is.Equal(w.Body.Len(), 0)
+}
+
+func TestServeContent_Cache(t *testing.T) {
+	is := is.New(t)
+
+	cache := NewETagCache(10)
+	modTime := time.Date(2021, 6, 9, 10, 18, 15, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+
+		ServeContent(w, r, "file.txt", modTime, strings.NewReader("content"), WithETagCache(cache))
+
+		is.Equal(w.Result().StatusCode, http.StatusOK)
+		is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("content")).String())
+		is.Equal(w.Body.String(), "content")
+	}
+
+	is.Equal(cache.Len(), 1)
+}
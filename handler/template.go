@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultTemplateCacheSize is the number of entity-tags held by the cache used by TemplateHandler if none is given.
+const defaultTemplateCacheSize = 1024
+
+// TemplateExecutor executes templates by name. It is implemented by *html/template.Template
+// and *text/template.Template.
+type TemplateExecutor interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+// TemplateDataFunc returns the data to execute a template with for r, together with a hash of that data.
+//
+// The hash is used as part of the memoization key for entity-tags (see TemplateHandler). It must change whenever
+// the data changes in a way that changes the template's output, and it must not depend on anything else, such
+// as pointer addresses. Two requests that produce the same hash for the same template must render identical output.
+type TemplateDataFunc func(r *http.Request) (data interface{}, dataHash string, err error)
+
+// TemplateHandler returns a handler that executes the template name using t, with the data returned by dataFunc,
+// and sends the rendered output together with a strong ETag header produced from a SHA-256 hash of the output.
+// The header name and the entity-tag's format can be configured using WithETagHeaderName and WithETagFormatter.
+// Conditional request headers are evaluated in the same way as by ServeWithValidators, using weak entity-tag
+// comparison by default.
+//
+// Entity-tags are memoized in cache, keyed by the template name and the data hash returned by dataFunc, so that
+// matching requests are answered with the 304 Not Modified status code without executing the template.
+// If cache is nil, a new cache with a default size is used. Errors are passed to the function configured using
+// WithErrorFunc, if any, and the 500 Internal Server Error status code is sent.
+func TemplateHandler(t TemplateExecutor, name string, dataFunc TemplateDataFunc, cache *ETagCache,
+	opts ...Option) http.Handler {
+
+	if cache == nil {
+		cache = NewETagCache(defaultTemplateCacheSize)
+	}
+
+	o := newOptions(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, dataHash, err := dataFunc(r)
+		if err != nil {
+			o.reportError(err, r)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		id, cacheable := varyIdentity(CacheIdentity{
+			Key: name + "\x00" + dataHash,
+		}, w.Header(), r)
+
+		var e ETag
+		cached := false
+		if cacheable {
+			e, cached = cache.Get(id)
+		}
+		if cached && serveMemoized(w, r, e, o) {
+			return
+		}
+
+		buf := bytes.Buffer{}
+		if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+			w.Header().Del(o.eTagHeader())
+			o.reportError(err, r)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if !cached {
+			e = templateETag(buf.Bytes(), r, o)
+			if cacheable && e.Tag != "" {
+				cache.Put(id, e)
+			}
+		}
+
+		serveWithValidators(w, r, buf.Bytes(), e, time.Time{}, o.weakComparison(r, true), o)
+	})
+}
+
+// serveMemoized sets the memoized entity-tag e in the response, and sends the 304 Not Modified status code
+// if the request's If-None-Match header matches it. It returns whether the response has been sent.
+func serveMemoized(w http.ResponseWriter, r *http.Request, e ETag, o *options) bool {
+	w.Header().Set(o.eTagHeader(), o.formatETag(e))
+
+	statusCode, _, _ := tryMatchETag(w, r, o, o.weakComparison(r, true), http.StatusOK)
+	if statusCode != http.StatusNotModified {
+		return false
+	}
+
+	w.WriteHeader(statusCode)
+	return true
+}
+
+// templateETag returns the entity-tag for the rendered output b, formatted according to o. If the entity-tag would
+// not be parsed back unchanged, ErrInvalidETag is reported, and an empty entity-tag is returned.
+func templateETag(b []byte, r *http.Request, o *options) ETag {
+	sum := sha256.Sum256(b)
+
+	e := o.digestETag(sha256Algo, sum[:])
+	if !o.roundTrips(e) {
+		o.reportError(ErrInvalidETag, r)
+		return ETag{}
+	}
+
+	return e
+}
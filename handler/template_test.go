@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+
+	"github.com/matryer/is"
+)
+
+type countingExecutor struct {
+	t     *template.Template
+	calls int
+}
+
+func (c *countingExecutor) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	c.calls++
+	return c.t.ExecuteTemplate(w, name, data)
+}
+
+func TestTemplateHandler(t *testing.T) {
+	is := is.New(t)
+
+	tmpl := template.Must(template.New("page").Parse("Hello, {{.}}!"))
+	exec := &countingExecutor{t: tmpl}
+	h := TemplateHandler(exec, "page", func(r *http.Request) (interface{}, string, error) {
+		name := r.URL.Query().Get("name")
+		return name, name, nil
+	}, NewETagCache(10))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/?name=World", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("Hello, World!")).String())
+	b, _ := io.ReadAll(w.Result().Body)
+	is.Equal(string(b), "Hello, World!")
+	is.Equal(exec.calls, 1)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/?name=World", nil)
+	r.Header.Set("If-None-Match", StableETag([]byte("Hello, World!")).String())
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+	is.Equal(exec.calls, 1) // template not executed again
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/?name=Gopher", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), StableETag([]byte("Hello, Gopher!")).String())
+	is.Equal(exec.calls, 2)
+}
+
+func TestTemplateHandler_MemoizedETag(t *testing.T) {
+	is := is.New(t)
+
+	calls := 0
+	tmpl := template.Must(template.New("page").Funcs(template.FuncMap{
+		"calls": func() int {
+			calls++
+			return calls
+		},
+	}).Parse("render {{calls}}"))
+	h := TemplateHandler(tmpl, "page", func(r *http.Request) (interface{}, string, error) {
+		return nil, "data", nil
+	}, nil)
+
+	var eTags []string
+	for i := 1; i <= 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		h.ServeHTTP(w, r)
+
+		b, _ := io.ReadAll(w.Result().Body)
+		is.Equal(string(b), fmt.Sprintf("render %d", i))
+		eTags = append(eTags, w.Result().Header.Get("ETag"))
+	}
+
+	// the output is not hashed again, since the memoization key has not changed
+	is.Equal(eTags[0], StableETag([]byte("render 1")).String())
+	is.Equal(eTags[1], eTags[0])
+}
+
+func TestTemplateHandler_DataError(t *testing.T) {
+	is := is.New(t)
+
+	dataErr := errors.New("data error")
+	var errs []error
+	tmpl := template.Must(template.New("page").Parse("page"))
+	h := TemplateHandler(tmpl, "page", func(r *http.Request) (interface{}, string, error) {
+		return nil, "", dataErr
+	}, nil, WithErrorFunc(func(err error, r *http.Request) {
+		errs = append(errs, err)
+	}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusInternalServerError)
+	is.Equal(errs, []error{dataErr})
+}
+
+func TestTemplateHandler_Options(t *testing.T) {
+	is := is.New(t)
+
+	tmpl := template.Must(template.New("page").Parse("page"))
+	exec := &countingExecutor{t: tmpl}
+	h := TemplateHandler(exec, "page", func(r *http.Request) (interface{}, string, error) {
+		return nil, "data", nil
+	}, nil,
+		WithETagHeaderName("X-Origin-ETag"),
+		WithETagFormatter(func(algo string, sum []byte) ETag {
+			return ETag{Tag: algo + "-" + hex.EncodeToString(sum[:4])}
+		}))
+
+	sum := sha256.Sum256([]byte("page"))
+	want := `"sha256-` + hex.EncodeToString(sum[:4]) + `"`
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		h.ServeHTTP(w, r)
+
+		is.Equal(w.Result().StatusCode, http.StatusOK)
+		is.Equal(w.Result().Header.Get("X-Origin-ETag"), want)
+		is.Equal(w.Result().Header.Get("ETag"), "")
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", want)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusNotModified)
+	is.Equal(exec.calls, 2) // memoized entity-tag matched without executing the template
+}
+
+func TestTemplateHandler_InvalidETag(t *testing.T) {
+	is := is.New(t)
+
+	var errs []error
+	tmpl := template.Must(template.New("page").Parse("page"))
+	h := TemplateHandler(tmpl, "page", func(r *http.Request) (interface{}, string, error) {
+		return nil, "data", nil
+	}, nil,
+		WithETagFormatter(func(algo string, sum []byte) ETag {
+			return ETag{Tag: `a"b`}
+		}),
+		WithErrorFunc(func(err error, r *http.Request) {
+			errs = append(errs, err)
+		}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().StatusCode, http.StatusOK)
+	is.Equal(w.Result().Header.Get("ETag"), "")
+	is.Equal(errs, []error{ErrInvalidETag})
+}
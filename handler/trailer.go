@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETagTrailerHandler returns a handler that produces entity-tags for responses that are streamed by next, without
+// buffering their bodies. If next sets the Content-Length header, a weak entity-tag derived from the length is set
+// as the ETag header. Once the body has been sent, a strong entity-tag produced from a SHA-256 hash of the body,
+// in the same way as StableETag, is sent as the ETag trailer, and the Content-Length header is removed.
+//
+// If next sets the ETag header itself, the response is passed through unmodified. No validators are produced for
+// HEAD requests, and for responses whose status code does not permit a body.
+func ETagTrailerHandler(next http.Handler, opts ...Option) http.Handler {
+	if next == nil {
+		panic(ErrNilHandler)
+	}
+
+	o := newOptions(opts)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &trailerWriter{
+			w:    w,
+			r:    r,
+			o:    o,
+			hash: sha256.New(),
+		}
+
+		next.ServeHTTP(tw, r)
+
+		if !tw.headerWritten {
+			tw.WriteHeader(http.StatusOK)
+		}
+		if !tw.enabled {
+			return
+		}
+
+		e := ETag{
+			Tag: hex.EncodeToString(tw.hash.Sum(nil)),
+		}
+		w.Header().Set(http.TrailerPrefix+o.eTagHeader(), o.formatETag(e))
+	})
+}
+
+// trailerWriter is an http.ResponseWriter that hashes the body written to it while streaming it.
+type trailerWriter struct {
+	w             http.ResponseWriter
+	r             *http.Request
+	o             *options
+	hash          hash.Hash
+	headerWritten bool
+	enabled       bool
+}
+
+var _ http.Flusher = (*trailerWriter)(nil)
+
+// Header implements http.ResponseWriter.
+func (w *trailerWriter) Header() http.Header {
+	return w.w.Header()
+}
+
+// Write implements http.ResponseWriter.
+func (w *trailerWriter) Write(b []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	n, err := w.w.Write(b)
+	if w.enabled {
+		_, _ = w.hash.Write(b[:n])
+	}
+	return n, err
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *trailerWriter) WriteHeader(statusCode int) {
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+
+	w.enabled = w.r.Method != http.MethodHead && bodyAllowedForStatus(statusCode) &&
+		w.Header().Get(w.o.eTagHeader()) == ""
+
+	if w.enabled {
+		if cl, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+			e := ETag{
+				Tag:  strconv.FormatInt(cl, 10),
+				Weak: true,
+			}
+			w.Header().Set(w.o.eTagHeader(), w.o.formatETag(e))
+		}
+		w.Header().Del("Content-Length")
+	}
+
+	w.w.WriteHeader(statusCode)
+}
+
+// Flush implements http.Flusher. It flushes the underlying http.ResponseWriter, if it supports flushing.
+func (w *trailerWriter) Flush() {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// declaredTrailer returns whether header declares name as a trailer using the Trailer header.
+func declaredTrailer(header http.Header, name string) bool {
+	for _, v := range header.Values("Trailer") {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestETagTrailerHandler(t *testing.T) {
+	is := is.New(t)
+
+	body := []byte("chunkchunk")
+	h := ETagTrailerHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		_, _ = w.Write(body[:5])
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(body[5:])
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL)
+	is.NoErr(err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	is.Equal(res.StatusCode, http.StatusOK)
+	is.Equal(res.Header.Get("ETag"), `W/"10"`)
+
+	b, err := io.ReadAll(res.Body)
+	is.NoErr(err)
+	is.Equal(b, body)
+	is.Equal(res.Trailer.Get("ETag"), StableETag(body).String())
+}
+
+func TestETagTrailerHandler_ETagSet(t *testing.T) {
+	is := is.New(t)
+
+	h := ETagTrailerHandler(contentHandler([]byte("body"), "ETag", `"foo"`))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), `"foo"`)
+	is.Equal(len(w.Result().Trailer), 0)
+}
+
+func TestETagTrailerHandler_NoContentLength(t *testing.T) {
+	is := is.New(t)
+
+	h := ETagTrailerHandler(contentHandler([]byte("body")))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	h.ServeHTTP(w, r)
+
+	is.Equal(w.Result().Header.Get("ETag"), "")
+	is.Equal(w.Result().Trailer.Get("ETag"), StableETag([]byte("body")).String())
+}
+
+func TestIfNoneMatchIfModifiedSinceHandler_ETagInTrailer(t *testing.T) {
+	tests := []struct {
+		name string
+		next http.Handler
+	}{
+		{
+			name: "trailer prefix",
+			next: ETagTrailerHandler(contentHandler([]byte("body"))),
+		},
+		{
+			name: "declared trailer",
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", "ETag")
+				_, _ = w.Write([]byte("body"))
+				w.Header().Set("ETag", `"foo"`)
+			}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var errs []error
+			h := IfNoneMatchIfModifiedSinceHandler(false, test.next, WithErrorFunc(func(err error, r *http.Request) {
+				errs = append(errs, err)
+			}))
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-None-Match", StableETag([]byte("body")).String())
+
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Result().StatusCode, http.StatusOK)
+			is.Equal(errs, []error{ErrETagInTrailer})
+		})
+	}
+}